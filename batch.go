@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// forEachConcurrently calls fn for every index below n on at most workers
+// goroutines at a time, and returns once all calls are done.
+func forEachConcurrently(n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// onBatch runs method for every body of the array, batchConcurrency at a
+// time, and replies with their results in the same order. Items without a
+// reply shortly before the time out of the batch request fail, so the items
+// that did finish still reach the editor.
+func (s *mateServer) onBatch(mr mateRequest, method string, cb kvChan) {
+	bodies := []json.RawMessage{}
+	if err := json.Unmarshal(mr.Body, &bodies); err != nil {
+		cb <- &KeyValue{"result": "error", "message": err.Error()}
+		return
+	}
+	timeout := mr.replyWithin(0)
+	expired := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(expired) })
+	defer timer.Stop()
+
+	results := make([]*KeyValue, len(bodies))
+	forEachConcurrently(len(bodies), s.batchConcurrency, func(i int) {
+		timedOut := &KeyValue{"result": "error", "message": method + " timed out"}
+		select {
+		case <-expired:
+			results[i] = timedOut
+			return
+		default:
+		}
+		// buffered so a late reply doesn't block the handler, which is left
+		// running when the batch times out
+		itemCb := make(kvChan, 1)
+		go s.processRequest(mateRequest{Method: method, Body: bodies[i], Session: mr.Session, Timeout: timeout}, itemCb)
+		select {
+		case results[i] = <-itemCb:
+		case <-expired:
+			results[i] = timedOut
+		}
+	})
+	cb <- &KeyValue{"result": results}
+}
+
+// onResolveCompletionBatch resolves the completion items concurrently and
+// replies with them in the same order. Items that fail to resolve are
+// returned as they were sent.
+func (s *mateServer) onResolveCompletionBatch(mr mateRequest, cb kvChan) {
+	items := []CompletionItem{}
+	if err := json.Unmarshal(mr.Body, &items); err != nil {
+		cb <- &KeyValue{"result": "error", "message": err.Error()}
+		return
+	}
+
+	opts := s.clientOptions(mr.Session)
+	forEachConcurrently(len(items), s.batchConcurrency, func(i int) {
+		itemCb := make(kvChan, 1)
+		s.onResolveCompletion(items[i], mr.Session, opts, itemCb)
+		reply := <-itemCb
+		if resolved, ok := (*reply)["result"].(CompletionItem); ok {
+			items[i] = resolved
+		} else {
+			Log.WithField("label", items[i].Label).Warn((*reply)["message"])
+		}
+	})
+	cb <- &KeyValue{"result": items}
+}
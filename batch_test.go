@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForEachConcurrently(t *testing.T) {
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	done := make([]bool, 20)
+
+	forEachConcurrently(len(done), 3, func(i int) {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		lock.Lock()
+		running--
+		done[i] = true
+		lock.Unlock()
+	})
+
+	if maxRunning > 3 {
+		t.Errorf("Expected at most 3 calls at a time, but got %d", maxRunning)
+	}
+	for i, ok := range done {
+		if !ok {
+			t.Errorf("Call %d didn't run", i)
+		}
+	}
+	forEachConcurrently(0, 3, func(i int) { t.Errorf("Unexpected call %d", i) })
+}
+
+func TestOnBatch(t *testing.T) {
+	s := mateServer{rawResults: make(map[kvChan][]json.RawMessage), batchConcurrency: 2}
+	s.diagnostics.publish("file:///a.php", []Diagnostic{{Message: "syntax error"}})
+	// raw results reply from another goroutine
+	body := `[{"uri":"file:///a.php","rawResult":true},{"uri":"file:///a.php"},{"uri":"file:///b.php","rawResult":true}]`
+
+	cb := make(kvChan, 1)
+	s.onBatch(mateRequest{Method: "getDiagnostics", Body: json.RawMessage(body), Timeout: time.Second}, "getDiagnostics", cb)
+	results, ok := (*<-cb)["result"].([]*KeyValue)
+	if !ok || len(results) != 3 {
+		t.Fatalf("Expected 3 results, but got %v", results)
+	}
+	for i, result := range results {
+		if _, ok := (*result)["result"].(KeyValue); !ok {
+			t.Errorf("Item %d failed: %v", i, *result)
+		}
+	}
+}
+
+func TestOnBatchTimeout(t *testing.T) {
+	s := mateServer{
+		client:           &lspClient{out: &bufferCloser{}},
+		requestID:        initializeRequestID,
+		pending:          make(map[int]*pendingRequest),
+		batchConcurrency: 2,
+	}
+	body := `[{"textDocument":{"uri":"file:///a.php"},"position":{"line":0,"character":0}},` +
+		`{"textDocument":{"uri":"file:///b.php"},"position":{"line":0,"character":0}}]`
+	timeout := 300 * time.Millisecond
+
+	cb := make(kvChan, 1)
+	started := time.Now()
+	go s.onBatch(mateRequest{Method: "hoverBatch", Body: json.RawMessage(body), Timeout: timeout}, "hover", cb)
+	// only one of the hovers is answered
+	respond(t, &s, "textDocument/hover", json.RawMessage(`{"contents":"strlen"}`))
+
+	select {
+	case reply := <-cb:
+		if took := time.Since(started); took >= timeout {
+			t.Errorf("Expected a reply within %s, but it took %s", timeout, took)
+		}
+		results, ok := (*reply)["result"].([]*KeyValue)
+		if !ok || len(results) != 2 {
+			t.Fatalf("Expected 2 results, but got %v", *reply)
+		}
+		answered, timedOut := 0, 0
+		for _, result := range results {
+			if _, ok := (*result)["result"].(Hover); ok {
+				answered++
+			} else if (*result)["message"] == "hover timed out" {
+				timedOut++
+			}
+		}
+		if answered != 1 || timedOut != 1 {
+			t.Errorf("Expected one answered and one timed out hover, but got %v and %v", *results[0], *results[1])
+		}
+	case <-time.After(timeout):
+		t.Fatalf("Expected the batch to reply before the request times out")
+	}
+}
@@ -12,21 +12,29 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type config struct {
 	stdio  bool
 	url    string
 	params []string
+	// languages are the ids of the document languages the server supports
+	languages []string
 }
 
 type lspClient struct {
 	config       config
+	cmd          *exec.Cmd
 	reqID        int
 	in           io.ReadCloser
 	out          io.WriteCloser
 	responseChan chan *response
 	crashesCount int
+	// killed is set when the bridge killed the server on purpose
+	killed bool
+	// spawnTook is how long starting or connecting to the server took
+	spawnTook time.Duration
 	sync.Mutex
 }
 
@@ -41,6 +49,7 @@ func newLspClient(config config) *lspClient {
 }
 
 func (p *lspClient) connectToServer() {
+	started := time.Now()
 	if p.config.stdio {
 		cmd := exec.Command(p.config.url, p.config.params...)
 
@@ -59,13 +68,25 @@ func (p *lspClient) connectToServer() {
 		if err := cmd.Start(); err != nil {
 			checkError(err)
 		}
+		p.Lock()
+		p.cmd = cmd
+		p.spawnTook = time.Since(started)
+		p.Unlock()
 		go func() {
 			if err := cmd.Wait(); err != nil {
-				p.crashesCount++
-				if p.crashesCount == 10 {
-					checkError(err)
+				p.Lock()
+				killed := p.killed
+				p.killed = false
+				p.Unlock()
+				if killed {
+					Log.WithField("err", err).Info("Restarting killed server...")
+				} else {
+					p.crashesCount++
+					if p.crashesCount == 10 {
+						checkError(err)
+					}
+					Log.WithField("err", err).Info("Restarting server after a crash...")
 				}
-				Log.WithField("err", err).Info("Restarting server after a crash...")
 				go p.connectToServer()
 				p.responseChan <- &response{Method: "restart"}
 			}
@@ -75,11 +96,51 @@ func (p *lspClient) connectToServer() {
 		checkError(err)
 		p.in = conn
 		p.out = conn
+		p.Lock()
+		p.spawnTook = time.Since(started)
+		p.Unlock()
 	}
 
 	go p.listen()
 }
 
+// kill force-kills the language server process. It's restarted like after
+// a crash, without counting as one.
+func (p *lspClient) kill() {
+	p.Lock()
+	cmd := p.cmd
+	if cmd != nil && cmd.Process != nil {
+		p.killed = true
+	}
+	p.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	if err := cmd.Process.Kill(); err != nil {
+		p.Lock()
+		p.killed = false
+		p.Unlock()
+		Log.Error(err)
+	}
+}
+
+// pid returns the process id of the language server, 0 if it isn't running.
+func (p *lspClient) pid() int {
+	p.Lock()
+	defer p.Unlock()
+	if p.cmd == nil || p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
+// spawnTime returns how long the last start of the server took.
+func (p *lspClient) spawnTime() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return p.spawnTook
+}
+
 func (p *lspClient) listen() {
 	Log.Info("Listening for messages, ^c to exit")
 	for {
@@ -126,6 +187,8 @@ func (p *lspClient) processMessage(r *response) {
 		Log.Info(r.Params["message"])
 	} else if r.Method == "serenata/didProgressIndexing" {
 		Log.Info(r.Params["info"])
+		// the status follows indexing with it
+		p.responseChan <- r
 	} else {
 		Log.WithField("method", r.Method).WithField("params", r.Params).Trace(string(r.Result))
 		p.responseChan <- r
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// completionKey identifies the completion requests of a session for a
+// document.
+type completionKey struct {
+	session string
+	uri     DocumentURI
+}
+
+// cachedCompletion is the full list of a compacted completion.
+type cachedCompletion struct {
+	uri   DocumentURI
+	items []CompletionItem
+}
+
+func (s *mateServer) onCompletion(params CompletionParams, session string, opts clientOptions, cb kvChan) {
+	result, err := s.retry("textDocument/completion", opts, func() (json.RawMessage, error) {
+		return s.requestCompletion(params, session)
+	})
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	s.recordRaw(cb, result)
+	// explicit invocation shows everything, automatic triggers stay narrow and
+	// so do editors that don't say how completion was triggered
+	invoked := params.Context.TriggerKind == CTKInvoked
+	if invoked && !opts.rewritesCompletion() && len(result) > 0 && string(result) != "null" {
+		cb <- &KeyValue{"result": result}
+		return
+	}
+	list, err := decodeCompletion(result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+
+	list.Items = filterCompletionKinds(list.Items, opts.excludeKinds)
+	if opts.dedupeCompletion {
+		list.Items = dedupeCompletion(list.Items)
+	}
+	if opts.kindPriority != nil {
+		normalizeSortText(list.Items, opts.kindPriority)
+	}
+	for i := range list.Items {
+		if !opts.snippetSupport {
+			plainCompletion(&list.Items[i])
+		}
+		if opts.plainDocumentation {
+			plainDocumentation(&list.Items[i])
+		}
+	}
+	if params.Context.TriggerKind == CTKTriggerCharacter || params.Context.TriggerKind == CTKTriggerForIncompleteCompletions {
+		s.narrowCompletion(list, params.TextDocumentPositionParams)
+	}
+	if !invoked {
+		s.capCompletion(list)
+	}
+	if opts.compactThreshold > 0 && len(list.Items) > opts.compactThreshold {
+		s.cacheLock.Lock()
+		if s.completionCache == nil {
+			s.completionCache = make(map[string]cachedCompletion)
+		}
+		s.completionCache[session] = cachedCompletion{uri: params.TextDocument.URI, items: list.Items}
+		s.cacheLock.Unlock()
+		list = &CompletionList{IsIncomplete: list.IsIncomplete, Items: compactCompletion(list.Items)}
+	}
+	cb <- &KeyValue{"result": list}
+}
+
+func (s *mateServer) onResolveCompletion(item CompletionItem, session string, opts clientOptions, cb kvChan) {
+	item = s.expandCompletion(session, item)
+	result, err := s.callFor(opts, "completionItem/resolve", item)
+	s.recordRaw(cb, result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	resolved := CompletionItem{}
+	if err := json.Unmarshal(result, &resolved); err != nil {
+		replyError(cb, err)
+		return
+	}
+	if !opts.snippetSupport {
+		plainCompletion(&resolved)
+	}
+	if opts.plainDocumentation {
+		plainDocumentation(&resolved)
+	}
+	cb <- &KeyValue{"result": resolved}
+}
+
+// normalizeSortText rewrites the sortText of the items to a uniform scheme,
+// kind priority followed by the label, and orders the items by it.
+func normalizeSortText(items []CompletionItem, priority map[CompletionItemKind]int) {
+	for i := range items {
+		p, ok := priority[items[i].Kind]
+		if !ok {
+			p = len(priority)
+		}
+		items[i].SortText = fmt.Sprintf("%03d:%s", p, strings.ToLower(items[i].Label))
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].SortText < items[j].SortText
+	})
+}
+
+// compactCompletion strips items down to what the editor needs to show the
+// list. Data is kept so the item can be resolved later.
+func compactCompletion(items []CompletionItem) []CompletionItem {
+	compact := make([]CompletionItem, len(items))
+	for i, item := range items {
+		compact[i] = CompletionItem{Label: item.Label, Kind: item.Kind, Data: item.Data}
+	}
+	return compact
+}
+
+// flushCompletion forgets the last compacted completion list of the session,
+// if it's for the document or uri is empty. It returns the number of items
+// dropped.
+func (s *mateServer) flushCompletion(session string, uri DocumentURI) int {
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+	cached := s.completionCache[session]
+	if len(uri) > 0 && uri != cached.uri {
+		return 0
+	}
+	delete(s.completionCache, session)
+	return len(cached.items)
+}
+
+// expandCompletion returns the full item of the last compacted list of the
+// session that matches the compact one, or the item as is if there is none.
+func (s *mateServer) expandCompletion(session string, item CompletionItem) CompletionItem {
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+	for _, full := range s.completionCache[session].items {
+		if full.Label == item.Label && full.Kind == item.Kind && reflect.DeepEqual(full.Data, item.Data) {
+			return full
+		}
+	}
+	return item
+}
+
+// requestCompletion sends the completion request, canceling the previous one
+// of the session for the document, which is stale now.
+func (s *mateServer) requestCompletion(params CompletionParams, session string) (json.RawMessage, error) {
+	key := completionKey{session, params.TextDocument.URI}
+	p := s.request("textDocument/completion", params)
+	if prev := s.trackCompletion(key, p.id); prev > 0 {
+		s.cancel(prev)
+	}
+	result, err := p.wait()
+	s.untrackCompletion(key, p.id)
+	return result, err
+}
+
+// trackCompletion records id as the latest completion request of the session
+// for the document and returns the previous one, if any.
+func (s *mateServer) trackCompletion(key completionKey, id int) int {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+	prev := s.completions[key]
+	s.completions[key] = id
+	return prev
+}
+
+func (s *mateServer) untrackCompletion(key completionKey, id int) {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+	if s.completions[key] == id {
+		delete(s.completions, key)
+	}
+}
+
+// narrowCompletion keeps the items matching the word typed before the cursor.
+func (s *mateServer) narrowCompletion(list *CompletionList, params TextDocumentPositionParams) {
+	if doc, ok := s.document(params.TextDocument.URI); ok {
+		list.Items = filterCompletionPrefix(list.Items, wordBefore(doc.Text, params.Position))
+	}
+}
+
+// capCompletion cuts the list at completion.maxItems. The server gets a higher
+// limit, so explicitly invoked completion isn't cut.
+func (s *mateServer) capCompletion(list *CompletionList) {
+	completion, _ := toKeyValue(s.configuration()["completion"])
+	if maxItems := completion.int("maxItems", 100); maxItems > 0 && len(list.Items) > maxItems {
+		list.Items = list.Items[:maxItems]
+		list.IsIncomplete = true
+	}
+}
+
+// filterCompletionPrefix drops the items that don't start with prefix,
+// ignoring case.
+func filterCompletionPrefix(items []CompletionItem, prefix string) []CompletionItem {
+	if len(prefix) == 0 {
+		return items
+	}
+	prefix = strings.ToLower(prefix)
+	filtered := items[:0]
+	for _, item := range items {
+		text := item.FilterText
+		if len(text) == 0 {
+			text = item.Label
+		}
+		if strings.HasPrefix(strings.ToLower(text), prefix) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// wordBefore returns the identifier ending at the position, including a
+// leading $ of variables.
+func wordBefore(text string, pos Position) string {
+	lines := strings.Split(text, "\n")
+	if pos.Line < 0 || pos.Line >= len(lines) {
+		return ""
+	}
+	line := []rune(lines[pos.Line])
+	end := pos.Character
+	if end > len(line) {
+		end = len(line)
+	}
+	start := end
+	for start > 0 && isWordChar(line[start-1]) {
+		start--
+	}
+	return string(line[start:end])
+}
+
+func isWordChar(ch rune) bool {
+	return ch == '_' || ch == '$' || unicode.IsLetter(ch) || unicode.IsDigit(ch)
+}
+
+// decodeCompletion parses a completion result, which is either a
+// CompletionList, a bare array of items or null.
+func decodeCompletion(result json.RawMessage) (*CompletionList, error) {
+	list := &CompletionList{Items: []CompletionItem{}}
+	if len(result) == 0 || string(result) == "null" {
+		return list, nil
+	}
+	if result[0] == '[' {
+		err := json.Unmarshal(result, &list.Items)
+		return list, err
+	}
+	err := json.Unmarshal(result, list)
+	if list.Items == nil {
+		list.Items = []CompletionItem{}
+	}
+	return list, err
+}
+
+// filterCompletionKinds drops the items of the excluded kinds.
+func filterCompletionKinds(items []CompletionItem, exclude map[CompletionItemKind]bool) []CompletionItem {
+	if len(exclude) == 0 {
+		return items
+	}
+	filtered := items[:0]
+	for _, item := range items {
+		if !exclude[item.Kind] {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// dedupeCompletion collapses items with identical label and kind, keeping the
+// position of the first one and the content of the most descriptive one.
+func dedupeCompletion(items []CompletionItem) []CompletionItem {
+	type key struct {
+		label string
+		kind  CompletionItemKind
+	}
+	seen := make(map[key]int, len(items))
+	deduped := make([]CompletionItem, 0, len(items))
+	for _, item := range items {
+		k := key{item.Label, item.Kind}
+		i, ok := seen[k]
+		if !ok {
+			seen[k] = len(deduped)
+			deduped = append(deduped, item)
+			continue
+		}
+		if completionRichness(item) > completionRichness(deduped[i]) {
+			deduped[i] = item
+		}
+	}
+	return deduped
+}
+
+// completionRichness scores how much detail an item carries.
+func completionRichness(item CompletionItem) int {
+	score := len(item.Detail)
+	if item.Documentation != nil {
+		score += len(item.Documentation.Value)
+	}
+	return score
+}
+
+// plainCompletion turns the snippet insert of the item into plain text,
+// keeping placeholder defaults and dropping tab stops.
+func plainCompletion(item *CompletionItem) {
+	if item.InsertTextFormat != ITFSnippet {
+		return
+	}
+	item.InsertText = plainSnippet(item.InsertText)
+	if item.TextEdit != nil {
+		item.TextEdit.NewText = plainSnippet(item.TextEdit.NewText)
+	}
+	item.InsertTextFormat = ITFPlainText
+}
+
+// plainSnippet returns the text a snippet expands to without an editor:
+// tab stops and variables are removed, placeholders and choices are
+// replaced by their default.
+func plainSnippet(snippet string) string {
+	text, _ := expandSnippet([]rune(snippet), 0, false)
+	return text
+}
+
+// expandSnippet expands r from i up to its end or, when nested, up to the
+// closing brace of a placeholder. It returns the text and the index it
+// stopped at.
+func expandSnippet(r []rune, i int, nested bool) (string, int) {
+	var out strings.Builder
+	for i < len(r) {
+		switch {
+		case r[i] == '\\' && i+1 < len(r) && strings.ContainsRune(`$}\,|`, r[i+1]):
+			out.WriteRune(r[i+1])
+			i += 2
+		case r[i] == '}' && nested:
+			return out.String(), i
+		case r[i] == '$' && i+1 < len(r) && isWordChar(r[i+1]) && r[i+1] != '$':
+			// $1 or $name
+			i++
+			for i < len(r) && isWordChar(r[i]) && r[i] != '$' {
+				i++
+			}
+		case r[i] == '$' && i+1 < len(r) && r[i+1] == '{':
+			j := i + 2
+			for j < len(r) && isWordChar(r[j]) && r[j] != '$' {
+				j++
+			}
+			if j == i+2 || j >= len(r) {
+				out.WriteRune(r[i])
+				i++
+				continue
+			}
+			switch r[j] {
+			case '}':
+				i = j + 1
+			case ':':
+				text, end := expandSnippet(r, j+1, true)
+				out.WriteString(text)
+				i = end + 1
+			case '|':
+				choice, end := snippetChoice(r, j+1)
+				out.WriteString(choice)
+				i = end
+			default:
+				out.WriteRune(r[i])
+				i++
+			}
+		default:
+			out.WriteRune(r[i])
+			i++
+		}
+	}
+	return out.String(), i
+}
+
+// snippetChoice returns the first option of the choice starting at i and the
+// index after its closing "|}".
+func snippetChoice(r []rune, i int) (string, int) {
+	var first strings.Builder
+	done := false
+	for i < len(r) {
+		switch {
+		case r[i] == '\\' && i+1 < len(r):
+			if !done {
+				first.WriteRune(r[i+1])
+			}
+			i += 2
+			continue
+		case r[i] == '|' && i+1 < len(r) && r[i+1] == '}':
+			return first.String(), i + 2
+		case r[i] == ',':
+			done = true
+		case !done:
+			first.WriteRune(r[i])
+		}
+		i++
+	}
+	return first.String(), i
+}
+
+// onFlushCache drops the results the bridge keeps for the session, for one
+// document when the request has a uri. Completion items of a compacted list
+// are the only results cached, hover and definition always come from the
+// server.
+func (s *mateServer) onFlushCache(mr mateRequest, cb kvChan) {
+	params := struct {
+		URI DocumentURI `json:"uri"`
+	}{}
+	// the whole cache is flushed without a body
+	if len(mr.Body) > 0 {
+		if err := json.Unmarshal(mr.Body, &params); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+	}
+	cb <- &KeyValue{"result": KeyValue{"completion": s.flushCompletion(mr.Session, params.URI)}}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlainSnippet(t *testing.T) {
+	tests := []struct {
+		snippet string
+		want    string
+	}{
+		{"strlen($0)", "strlen()"},
+		{"array_map(${1:\\$callback}, ${2:\\$array})$0", "array_map($callback, $array)"},
+		{"foreach (${1:\\$array} as ${2:\\$key} => ${3:\\$value}) {\n\t$0\n}", "foreach ($array as $key => $value) {\n\t\n}"},
+		{"${1|public,protected,private|} function ${2:name}()", "public function name()"},
+		{"${1:outer ${2:inner}}", "outer inner"},
+		{"$TM_SELECTED_TEXT ${3}", " "},
+		{"\\$x = 1;", "$x = 1;"},
+		{"cost: $", "cost: $"},
+	}
+
+	for _, test := range tests {
+		if got := plainSnippet(test.snippet); got != test.want {
+			t.Errorf("Expanded %q, expected %q, but got %q", test.snippet, test.want, got)
+		}
+	}
+}
+
+func TestFilterCompletionKinds(t *testing.T) {
+	items := []CompletionItem{
+		{Label: "strlen", Kind: CIKFunction},
+		{Label: "PHP_EOL", Kind: CIKConstant},
+		{Label: "Foo", Kind: CIKClass},
+		{Label: "E_ALL", Kind: CIKConstant},
+	}
+	tests := []struct {
+		exclude map[CompletionItemKind]bool
+		want    []string
+	}{
+		{nil, []string{"strlen", "PHP_EOL", "Foo", "E_ALL"}},
+		{map[CompletionItemKind]bool{}, []string{"strlen", "PHP_EOL", "Foo", "E_ALL"}},
+		{map[CompletionItemKind]bool{CIKConstant: true}, []string{"strlen", "Foo"}},
+		{map[CompletionItemKind]bool{CIKFunction: true, CIKClass: true}, []string{"PHP_EOL", "E_ALL"}},
+		{map[CompletionItemKind]bool{CIKFunction: true, CIKClass: true, CIKConstant: true}, []string{}},
+		{map[CompletionItemKind]bool{CIKKeyword: true}, []string{"strlen", "PHP_EOL", "Foo", "E_ALL"}},
+	}
+
+	for _, test := range tests {
+		filtered := filterCompletionKinds(append([]CompletionItem(nil), items...), test.exclude)
+		got := completionLabels(filtered)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Excluding %v, expected %v, but got %v", test.exclude, test.want, got)
+		}
+	}
+}
+
+// completionLabels returns the labels of the items in order.
+func completionLabels(items []CompletionItem) []string {
+	labels := make([]string, len(items))
+	for i, item := range items {
+		labels[i] = item.Label
+	}
+	return labels
+}
+
+func TestCompletionTriggerKinds(t *testing.T) {
+	items := make([]CompletionItem, 150)
+	for i := range items {
+		items[i] = CompletionItem{Label: fmt.Sprintf("item%03d", i), Kind: CIKFunction}
+	}
+	items[0].Label = "strlen"
+	result, _ := json.Marshal(CompletionList{Items: items})
+
+	tests := []struct {
+		body       string
+		items      int
+		incomplete bool
+	}{
+		// editors that don't send a context get the configured maxItems
+		{`{"textDocument":{"uri":"file:///a.php"},"position":{"line":0,"character":9}}`, 100, true},
+		{`{"textDocument":{"uri":"file:///a.php"},"position":{"line":0,"character":9},"context":{"triggerKind":0}}`, 100, true},
+		{`{"textDocument":{"uri":"file:///a.php"},"position":{"line":0,"character":9},"context":{"triggerKind":1}}`, 150, false},
+		{`{"textDocument":{"uri":"file:///a.php"},"position":{"line":0,"character":6},"context":{"triggerKind":2}}`, 100, true},
+		// the prefix typed before the cursor narrows the list first
+		{`{"textDocument":{"uri":"file:///a.php"},"position":{"line":0,"character":8},"context":{"triggerKind":3}}`, 1, false},
+	}
+
+	for _, test := range tests {
+		s := mateServer{
+			client:      &lspClient{out: &bufferCloser{}},
+			openFiles:   map[string]*openDocument{"file:///a.php": {item: TextDocumentItem{URI: "file:///a.php", Text: "<?php st"}}},
+			requestID:   initializeRequestID,
+			pending:     make(map[int]*pendingRequest),
+			completions: make(map[completionKey]int),
+			config:      defaultConfig(),
+		}
+		params := CompletionParams{}
+		if err := json.Unmarshal([]byte(test.body), &params); err != nil {
+			t.Fatal(err)
+		}
+		cb := make(kvChan, 1)
+		go s.onCompletion(params, "", parseClientOptions(KeyValue{}), cb)
+		respond(t, &s, "textDocument/completion", result)
+
+		list, err := decodeCompletion(completionResult(t, <-cb))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Items) != test.items || list.IsIncomplete != test.incomplete {
+			t.Errorf("Completion %s, expected %d items (incomplete %v), but got %d (incomplete %v)",
+				test.body, test.items, test.incomplete, len(list.Items), list.IsIncomplete)
+		}
+	}
+}
+
+// respond answers the first pending request for method with result.
+func respond(t *testing.T, s *mateServer, method string, result json.RawMessage) {
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		s.pendingLock.Lock()
+		id := 0
+		for _, p := range s.pending {
+			if p.method == method && (id == 0 || p.id < id) {
+				id = p.id
+			}
+		}
+		s.pendingLock.Unlock()
+		if id > 0 {
+			s.resolve(&response{ID: id, Result: result})
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("No %s request was sent", method)
+		}
+	}
+}
+
+// completionResult returns the completion reply as sent to the editor.
+func completionResult(t *testing.T, reply *KeyValue) json.RawMessage {
+	if raw, ok := (*reply)["result"].(json.RawMessage); ok {
+		return raw
+	}
+	data, err := json.Marshal((*reply)["result"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDedupeCompletion(t *testing.T) {
+	doc := func(value string) *MarkupContent { return &MarkupContent{Kind: MKPlainText, Value: value} }
+	tests := []struct {
+		items []CompletionItem
+		want  []CompletionItem
+	}{{
+		// same label, different kinds are different items
+		items: []CompletionItem{{Label: "count", Kind: CIKFunction}, {Label: "count", Kind: CIKProperty}},
+		want:  []CompletionItem{{Label: "count", Kind: CIKFunction}, {Label: "count", Kind: CIKProperty}},
+	}, {
+		// the richest duplicate takes the place of the first one
+		items: []CompletionItem{
+			{Label: "strlen", Kind: CIKFunction},
+			{Label: "PHP_EOL", Kind: CIKConstant},
+			{Label: "strlen", Kind: CIKFunction, Detail: "strlen(string $string): int", Documentation: doc("Get string length")},
+			{Label: "strlen", Kind: CIKFunction, Detail: "strlen()"},
+		},
+		want: []CompletionItem{
+			{Label: "strlen", Kind: CIKFunction, Detail: "strlen(string $string): int", Documentation: doc("Get string length")},
+			{Label: "PHP_EOL", Kind: CIKConstant},
+		},
+	}, {
+		// equally rich duplicates keep the first one
+		items: []CompletionItem{{Label: "Foo", Kind: CIKClass, Detail: "\\App\\Foo"}, {Label: "Foo", Kind: CIKClass, Detail: "\\Lib\\Foo"}},
+		want:  []CompletionItem{{Label: "Foo", Kind: CIKClass, Detail: "\\App\\Foo"}},
+	}, {
+		items: []CompletionItem{},
+		want:  []CompletionItem{},
+	}}
+
+	for _, test := range tests {
+		if got := dedupeCompletion(test.items); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Deduped %+v, expected %+v, but got %+v", test.items, test.want, got)
+		}
+	}
+}
+
+func TestNormalizeSortText(t *testing.T) {
+	priority := map[CompletionItemKind]int{CIKVariable: 0, CIKMethod: 1, CIKFunction: 2}
+	tests := []struct {
+		items []CompletionItem
+		want  []string
+	}{{
+		// the server sortText is replaced, numeric or not
+		items: []CompletionItem{
+			{Label: "strlen", Kind: CIKFunction, SortText: "0001"},
+			{Label: "$name", Kind: CIKVariable, SortText: "zzz"},
+			{Label: "getName", Kind: CIKMethod},
+		},
+		want: []string{"000:$name", "001:getname", "002:strlen"},
+	}, {
+		// kinds without a priority go last, ordered by label ignoring case
+		items: []CompletionItem{
+			{Label: "Foo", Kind: CIKClass, SortText: "a"},
+			{Label: "bar", Kind: CIKKeyword, SortText: "b"},
+			{Label: "count", Kind: CIKFunction},
+		},
+		want: []string{"002:count", "003:bar", "003:foo"},
+	}}
+
+	for _, test := range tests {
+		items := append([]CompletionItem(nil), test.items...)
+		normalizeSortText(items, priority)
+		got := make([]string, len(items))
+		for i, item := range items {
+			got[i] = item.SortText
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Normalized %+v, expected %v, but got %v", test.items, test.want, got)
+		}
+	}
+
+	// equal sort texts keep the server order
+	items := []CompletionItem{{Label: "Foo", Kind: CIKFunction, Detail: "first"}, {Label: "foo", Kind: CIKFunction, Detail: "second"}}
+	normalizeSortText(items, priority)
+	if items[0].Detail != "first" {
+		t.Errorf("Expected items with equal sort texts to keep their order, but got %+v", items)
+	}
+}
+
+func TestCompactExpandCompletion(t *testing.T) {
+	var items []CompletionItem
+	data := `[{"label":"strlen","kind":3,"detail":"strlen(string $string): int","insertText":"strlen($0)","data":{"id":1,"uri":"file:///a.php"}},` +
+		`{"label":"strlen","kind":3,"detail":"\\Lib\\strlen()","data":{"id":2,"uri":"file:///a.php"}},` +
+		`{"label":"PHP_EOL","kind":21}]`
+	if err := json.Unmarshal([]byte(data), &items); err != nil {
+		t.Fatal(err)
+	}
+
+	compact := compactCompletion(items)
+	for i, item := range compact {
+		if item.Label != items[i].Label || item.Kind != items[i].Kind || !reflect.DeepEqual(item.Data, items[i].Data) {
+			t.Errorf("Compact item %d lost its identity: %+v", i, item)
+		}
+		if len(item.Detail) > 0 || len(item.InsertText) > 0 {
+			t.Errorf("Expected compact item %d without details, but got %+v", i, item)
+		}
+	}
+
+	s := mateServer{completionCache: map[string]cachedCompletion{"": {uri: "file:///a.php", items: items}}}
+	tests := []struct {
+		item string
+		want string
+	}{
+		// the editor sends the compact item back as JSON
+		{`{"label":"strlen","kind":3,"data":{"id":2,"uri":"file:///a.php"}}`, `\Lib\strlen()`},
+		{`{"label":"strlen","kind":3,"data":{"id":1,"uri":"file:///a.php"}}`, "strlen(string $string): int"},
+		// no match with other data or kind, the item is resolved as is
+		{`{"label":"strlen","kind":3,"data":{"id":3,"uri":"file:///a.php"}}`, ""},
+		{`{"label":"PHP_EOL","kind":3}`, ""},
+	}
+	for _, test := range tests {
+		item := CompletionItem{}
+		if err := json.Unmarshal([]byte(test.item), &item); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.expandCompletion("", item); got.Detail != test.want {
+			t.Errorf("Expanded %s, expected detail %q, but got %+v", test.item, test.want, got)
+		}
+	}
+}
+
+func TestRequestCompletionCancelsSuperseded(t *testing.T) {
+	out := &bufferCloser{}
+	s := mateServer{
+		client:      &lspClient{out: out},
+		requestID:   initializeRequestID,
+		pending:     make(map[int]*pendingRequest),
+		completions: make(map[completionKey]int),
+	}
+	params := CompletionParams{TextDocumentPositionParams: TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: "file:///a.php"}}}
+
+	superseded := make(chan error, 1)
+	go func() {
+		_, err := s.requestCompletion(params, "one")
+		superseded <- err
+	}()
+	first := pendingID(t, &s, "textDocument/completion")
+	latest := make(chan error, 1)
+	go func() {
+		_, err := s.requestCompletion(params, "one")
+		latest <- err
+	}()
+
+	if err := <-superseded; !isErrorCode(err, RequestCancelled) {
+		t.Errorf("Expected the superseded completion to be canceled, but got %v", err)
+	}
+	if !strings.Contains(out.String(), `"method":"$/cancelRequest","params":{"id":`+strconv.Itoa(first)+`}`) {
+		t.Errorf("Expected $/cancelRequest for %d, but sent %s", first, out.String())
+	}
+	s.pendingLock.Lock()
+	_, stillPending := s.pending[first]
+	s.pendingLock.Unlock()
+	if stillPending {
+		t.Errorf("Expected the superseded completion to be dropped from the pending requests")
+	}
+
+	respond(t, &s, "textDocument/completion", json.RawMessage(`[]`))
+	if err := <-latest; err != nil {
+		t.Errorf("Expected the latest completion to be answered, but got %v", err)
+	}
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+	if len(s.completions) != 0 {
+		t.Errorf("Expected no tracked completions once answered, but got %v", s.completions)
+	}
+}
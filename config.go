@@ -0,0 +1,222 @@
+package main
+
+import "strings"
+
+// invokedMaxItems is the completion limit served to the language server, so
+// explicitly invoked completion gets the full list. The configured
+// completion.maxItems caps any other completion in the bridge.
+const invokedMaxItems = 5000
+
+// defaultConfig returns the settings served to the language server on
+// workspace/configuration requests until the editor overrides them.
+func defaultConfig() KeyValue {
+	return KeyValue{
+		"files": KeyValue{
+			"maxSize":      300000,
+			"associations": []string{"*.php", "*.phtml"},
+			"exclude": []string{
+				"**/.git/**",
+				"**/.svn/**",
+				"**/.hg/**",
+				"**/CVS/**",
+				"**/.DS_Store/**",
+				"**/node_modules/**",
+				"**/bower_components/**",
+				"**/vendor/**/{Test,test,Tests,tests}/**",
+				"**/.git",
+				"**/.svn",
+				"**/.hg",
+				"**/CVS",
+				"**/.DS_Store",
+				"**/nova/tests/**",
+				"**/faker/**",
+				"**/*.log",
+				"**/*.log*",
+				"**/*.min.*",
+				"**/dist",
+				"**/coverage",
+				"**/build/*",
+				"**/nova/public/*",
+				"**/public/*",
+			},
+		},
+		"stubs": []string{
+			"apache",
+			"bcmath",
+			"bz2",
+			"calendar",
+			"com_dotnet",
+			"Core",
+			"ctype",
+			"curl",
+			"date",
+			"dba",
+			"dom",
+			"enchant",
+			"exif",
+			"fileinfo",
+			"filter",
+			"fpm",
+			"ftp",
+			"gd",
+			"hash",
+			"iconv",
+			"imap",
+			"interbase",
+			"intl",
+			"json",
+			"ldap",
+			"libxml",
+			"mbstring",
+			"mcrypt",
+			"meta",
+			"mssql",
+			"mysqli",
+			"oci8",
+			"odbc",
+			"openssl",
+			"pcntl",
+			"pcre",
+			"PDO",
+			"pdo_ibm",
+			"pdo_mysql",
+			"pdo_pgsql",
+			"pdo_sqlite",
+			"pgsql",
+			"Phar",
+			"posix",
+			"pspell",
+			"readline",
+			"recode",
+			"Reflection",
+			"regex",
+			"session",
+			"shmop",
+			"SimpleXML",
+			"snmp",
+			"soap",
+			"sockets",
+			"sodium",
+			"SPL",
+			"sqlite3",
+			"standard",
+			"superglobals",
+			"sybase",
+			"sysvmsg",
+			"sysvsem",
+			"sysvshm",
+			"tidy",
+			"tokenizer",
+			"wddx",
+			"xml",
+			"xmlreader",
+			"xmlrpc",
+			"xmlwriter",
+			"Zend OPcache",
+			"zip",
+			"zlib",
+		},
+		"completion": KeyValue{
+			"insertUseDeclaration":                    true,
+			"fullyQualifyGlobalConstantsAndFunctions": false,
+			"triggerParameterHints":                   true,
+			"maxItems":                                100,
+		},
+		"format": KeyValue{
+			"enable": false,
+		},
+		"environment": KeyValue{
+			"documentRoot": "",
+			"includePaths": []string{},
+		},
+		"runtime":   "",
+		"maxMemory": 0,
+		"telemetry": KeyValue{"enabled": false},
+		"trace": KeyValue{
+			"server": "verbose",
+		},
+	}
+}
+
+// mergeConfig returns a copy of base with the values of override applied on
+// top of it. Nested objects are merged recursively, any other value replaces
+// the existing one. Dotted keys like "completion.maxItems" address nested
+// settings.
+func mergeConfig(base, override KeyValue) KeyValue {
+	merged := make(KeyValue, len(base))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		if i := strings.Index(k, "."); i > 0 {
+			k, v = k[:i], KeyValue{k[i+1:]: v}
+		}
+		if ov, ok := toKeyValue(v); ok {
+			if bv, ok := toKeyValue(merged[k]); ok {
+				merged[k] = mergeConfig(bv, ov)
+				continue
+			}
+			v = mergeConfig(KeyValue{}, ov)
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// toKeyValue converts decoded JSON objects to KeyValue.
+func toKeyValue(v interface{}) (KeyValue, bool) {
+	switch m := v.(type) {
+	case KeyValue:
+		return m, true
+	case map[string]interface{}:
+		return KeyValue(m), true
+	}
+	return nil, false
+}
+
+// withStubs returns a copy of cfg with its stubs replaced by stubs, if given,
+// and extended by extra.
+func withStubs(cfg KeyValue, stubs, extra []string) KeyValue {
+	if stubs == nil && len(extra) == 0 {
+		return cfg
+	}
+	if stubs == nil {
+		stubs = cfg.strings("stubs", nil)
+	}
+	merged := make([]string, 0, len(stubs)+len(extra))
+	seen := make(map[string]bool, len(stubs)+len(extra))
+	for _, list := range [][]string{stubs, extra} {
+		for _, stub := range list {
+			if !seen[stub] {
+				seen[stub] = true
+				merged = append(merged, stub)
+			}
+		}
+	}
+	return mergeConfig(cfg, KeyValue{"stubs": merged})
+}
+
+// configSection returns the value at the dotted section path of cfg.
+func configSection(cfg KeyValue, section string) (interface{}, bool) {
+	var value interface{} = cfg
+	for _, key := range strings.Split(section, ".") {
+		kv, ok := toKeyValue(value)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = kv[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// serverConfig returns cfg as served to the language server, with the
+// completion limit lifted to invokedMaxItems.
+func serverConfig(cfg KeyValue) KeyValue {
+	completion, _ := toKeyValue(cfg["completion"])
+	if completion.int("maxItems", 100) >= invokedMaxItems {
+		return cfg
+	}
+	return mergeConfig(cfg, KeyValue{"completion.maxItems": invokedMaxItems})
+}
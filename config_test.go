@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergeConfig(t *testing.T) {
+	base := KeyValue{
+		"format":     KeyValue{"enable": false},
+		"completion": KeyValue{"maxItems": 100, "insertUseDeclaration": true},
+		"stubs":      []string{"Core"},
+	}
+	tests := []struct {
+		override string
+		want     KeyValue
+	}{{
+		override: `{"format":{"enable":true}}`,
+		want: KeyValue{
+			"format":     KeyValue{"enable": true},
+			"completion": KeyValue{"maxItems": 100, "insertUseDeclaration": true},
+			"stubs":      []string{"Core"},
+		},
+	}, {
+		override: `{"completion.maxItems":20,"stubs":["Core","pdo"]}`,
+		want: KeyValue{
+			"format":     KeyValue{"enable": false},
+			"completion": KeyValue{"maxItems": float64(20), "insertUseDeclaration": true},
+			"stubs":      []interface{}{"Core", "pdo"},
+		},
+	}, {
+		override: `{"environment":{"phpVersion":"7.4"}}`,
+		want: KeyValue{
+			"format":      KeyValue{"enable": false},
+			"completion":  KeyValue{"maxItems": 100, "insertUseDeclaration": true},
+			"stubs":       []string{"Core"},
+			"environment": KeyValue{"phpVersion": "7.4"},
+		},
+	}}
+
+	for _, test := range tests {
+		override := KeyValue{}
+		if err := json.Unmarshal([]byte(test.override), &override); err != nil {
+			t.Errorf("json.Unmarshal error: %s", err)
+			continue
+		}
+		got := mergeConfig(base, override)
+		if !reflect.DeepEqual(test.want, got) {
+			t.Errorf("Merged %s, expected %+v, but got %+v", test.override, test.want, got)
+		}
+	}
+	if enabled := base["format"].(KeyValue)["enable"]; enabled != false {
+		t.Errorf("base config was modified by merge")
+	}
+}
+
+func TestConfigSection(t *testing.T) {
+	cfg := mergeConfig(defaultConfig(), KeyValue{"completion.maxItems": float64(20)})
+	if value, ok := configSection(cfg, "completion.maxItems"); !ok || value != float64(20) {
+		t.Errorf("Expected completion.maxItems 20, but got %v (%v)", value, ok)
+	}
+	if _, ok := configSection(cfg, "completion.maxItems.nested"); ok {
+		t.Errorf("Expected no section below a value")
+	}
+	if _, ok := configSection(cfg, "unknown"); ok {
+		t.Errorf("Expected no unknown section")
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	cfg := defaultConfig()
+	served := serverConfig(cfg)
+	if value, _ := configSection(served, "completion.maxItems"); value != invokedMaxItems {
+		t.Errorf("Expected the server limit %d, but got %v", invokedMaxItems, value)
+	}
+	if value, _ := configSection(cfg, "completion.maxItems"); value != 100 {
+		t.Errorf("Expected the configured limit to stay 100, but got %v", value)
+	}
+	if value, _ := configSection(served, "completion.insertUseDeclaration"); value != true {
+		t.Errorf("Expected other completion settings to be kept, but got %v", value)
+	}
+}
+
+func TestWithStubs(t *testing.T) {
+	cfg := KeyValue{"stubs": []string{"Core", "standard"}, "runtime": ""}
+	tests := []struct {
+		stubs []string
+		extra []string
+		want  []string
+	}{
+		{nil, nil, []string{"Core", "standard"}},
+		{nil, []string{"wordpress"}, []string{"Core", "standard", "wordpress"}},
+		// extra stubs already in the list, or listed twice, are added once
+		{nil, []string{"standard", "laravel", "laravel"}, []string{"Core", "standard", "laravel"}},
+		{[]string{"Core", "Core", "pdo"}, nil, []string{"Core", "pdo"}},
+		{[]string{"Core"}, []string{"Core", "swoole"}, []string{"Core", "swoole"}},
+		// an empty list replaces the built-in one
+		{[]string{}, []string{"swoole"}, []string{"swoole"}},
+	}
+
+	for _, test := range tests {
+		got := withStubs(cfg, test.stubs, test.extra)
+		if stubs := got.strings("stubs", nil); !reflect.DeepEqual(stubs, test.want) {
+			t.Errorf("Stubs %v and extra %v, expected %v, but got %v", test.stubs, test.extra, test.want, stubs)
+		}
+		if got["runtime"] != "" {
+			t.Errorf("Expected the other settings to be kept, but got %+v", got)
+		}
+	}
+	if stubs := cfg.strings("stubs", nil); !reflect.DeepEqual(stubs, []string{"Core", "standard"}) {
+		t.Errorf("withStubs modified the config: %v", stubs)
+	}
+}
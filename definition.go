@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// definitionKindTimeout bounds looking up the symbol kinds of definitions,
+// so they don't slow down jumping to them.
+const definitionKindTimeout = 300 * time.Millisecond
+
+// definitionTarget is a definition location with the kind of the symbol
+// defined there, if it could be found.
+type definitionTarget struct {
+	Location
+	Kind SymbolKind `json:"kind,omitempty"`
+}
+
+// symbolRange is the range a symbol of some kind spans.
+type symbolRange struct {
+	kind SymbolKind
+	rng  Range
+	// selection is the range of the symbol name
+	selection Range
+	name      string
+	detail    string
+}
+
+func (s *mateServer) onDefinition(params TextDocumentPositionParams, opts clientOptions, cb kvChan) {
+	result, err := s.callFor(opts, "textDocument/definition", params)
+	s.recordRaw(cb, result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	if !opts.definitionKind {
+		cb <- &KeyValue{"result": result}
+		return
+	}
+	locations, err := decodeLocations(result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+
+	targets := make([]definitionTarget, len(locations))
+	symbols := map[DocumentURI][]symbolRange{}
+	deadline := time.Now().Add(definitionKindTimeout)
+	for i, location := range locations {
+		targets[i].Location = location
+		ranges, ok := symbols[location.URI]
+		if !ok {
+			ranges = s.symbolRanges(location.URI, time.Until(deadline))
+			symbols[location.URI] = ranges
+		}
+		targets[i].Kind = innermostSymbol(ranges, location.Range.Start)
+	}
+	cb <- &KeyValue{"result": targets}
+}
+
+// symbolRanges returns the symbols of the document, or none if the server
+// doesn't answer within the timeout.
+func (s *mateServer) symbolRanges(uri DocumentURI, timeout time.Duration) []symbolRange {
+	if timeout <= 0 {
+		return nil
+	}
+	p := s.backgroundRequest("textDocument/documentSymbol", DocumentSymbolParams{TextDocumentIdentifier{uri}})
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		s.cancel(p.id)
+		Log.WithField("uri", uri).Debug("documentSymbol is too slow for the definition kind")
+		return nil
+	case r := <-p.done:
+		if r.Error != nil {
+			return nil
+		}
+		ranges, err := flattenSymbols(r.Result)
+		if err != nil {
+			Log.Warn(err)
+		}
+		return ranges
+	}
+}
+
+// decodeLocations parses a definition result: a Location, an array of
+// Locations or LocationLinks, or null.
+func decodeLocations(result json.RawMessage) ([]Location, error) {
+	if len(result) == 0 || string(result) == "null" {
+		return []Location{}, nil
+	}
+	if result[0] != '[' {
+		location := Location{}
+		err := json.Unmarshal(result, &location)
+		return []Location{location}, err
+	}
+	raw := []json.RawMessage{}
+	if err := json.Unmarshal(result, &raw); err != nil {
+		return nil, err
+	}
+	locations := make([]Location, 0, len(raw))
+	for _, item := range raw {
+		link := LocationLink{}
+		if err := json.Unmarshal(item, &link); err != nil {
+			return nil, err
+		}
+		if len(link.TargetURI) > 0 {
+			locations = append(locations, Location{URI: link.TargetURI, Range: link.TargetSelectionRange})
+			continue
+		}
+		location := Location{}
+		if err := json.Unmarshal(item, &location); err != nil {
+			return nil, err
+		}
+		locations = append(locations, location)
+	}
+	return locations, nil
+}
+
+// flattenSymbols parses a documentSymbol result, either SymbolInformation or
+// hierarchical DocumentSymbols, into a flat list.
+func flattenSymbols(result json.RawMessage) ([]symbolRange, error) {
+	probe := []map[string]json.RawMessage{}
+	if err := json.Unmarshal(result, &probe); err != nil || len(probe) == 0 {
+		return nil, err
+	}
+	if _, ok := probe[0]["location"]; ok {
+		infos := []SymbolInformation{}
+		if err := json.Unmarshal(result, &infos); err != nil {
+			return nil, err
+		}
+		ranges := make([]symbolRange, len(infos))
+		for i, info := range infos {
+			ranges[i] = symbolRange{kind: info.Kind, rng: info.Location.Range, selection: info.Location.Range, name: info.Name}
+		}
+		return ranges, nil
+	}
+	symbols := []DocumentSymbol{}
+	if err := json.Unmarshal(result, &symbols); err != nil {
+		return nil, err
+	}
+	var ranges []symbolRange
+	var walk func([]DocumentSymbol)
+	walk = func(symbols []DocumentSymbol) {
+		for _, symbol := range symbols {
+			ranges = append(ranges, symbolRange{kind: symbol.Kind, rng: symbol.Range, selection: symbol.SelectionRange, name: symbol.Name, detail: symbol.Detail})
+			walk(symbol.Children)
+		}
+	}
+	walk(symbols)
+	return ranges, nil
+}
+
+// innermostSymbol returns the kind of the smallest symbol containing the
+// position, 0 if there is none.
+func innermostSymbol(ranges []symbolRange, pos Position) SymbolKind {
+	var kind SymbolKind
+	var best *Range
+	for i, symbol := range ranges {
+		if !contains(symbol.rng, pos) {
+			continue
+		}
+		if best == nil || !before(symbol.rng.Start, best.Start) && !before(best.End, symbol.rng.End) {
+			kind = symbol.kind
+			best = &ranges[i].rng
+		}
+	}
+	return kind
+}
+
+func contains(r Range, pos Position) bool {
+	return !before(pos, r.Start) && !before(r.End, pos)
+}
+
+// before reports whether position a comes before b.
+func before(a, b Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeLocations(t *testing.T) {
+	tests := []struct {
+		result string
+		want   int
+	}{
+		{`null`, 0},
+		{`{"uri":"file:///a.php","range":{"start":{"line":1,"character":0},"end":{"line":1,"character":3}}}`, 1},
+		{`[{"uri":"file:///a.php","range":{"start":{"line":1,"character":0},"end":{"line":1,"character":3}}},{"uri":"file:///b.php","range":{"start":{"line":2,"character":0},"end":{"line":2,"character":3}}}]`, 2},
+		{`[{"targetUri":"file:///a.php","targetRange":{"start":{"line":1,"character":0},"end":{"line":9,"character":1}},"targetSelectionRange":{"start":{"line":1,"character":6},"end":{"line":1,"character":9}}}]`, 1},
+	}
+
+	for _, test := range tests {
+		locations, err := decodeLocations(json.RawMessage(test.result))
+		if err != nil {
+			t.Errorf("decodeLocations(%s) error: %s", test.result, err)
+			continue
+		}
+		if len(locations) != test.want {
+			t.Errorf("decodeLocations(%s) expected %d locations, but got %+v", test.result, test.want, locations)
+		}
+		for _, location := range locations {
+			if len(location.URI) == 0 {
+				t.Errorf("decodeLocations(%s) returned a location without uri", test.result)
+			}
+		}
+	}
+}
+
+func TestInnermostSymbol(t *testing.T) {
+	result := `[{"name":"Foo","kind":5,"range":{"start":{"line":2,"character":0},"end":{"line":20,"character":1}},"selectionRange":{"start":{"line":2,"character":6},"end":{"line":2,"character":9}},
+		"children":[{"name":"bar","kind":6,"range":{"start":{"line":4,"character":4},"end":{"line":8,"character":5}},"selectionRange":{"start":{"line":4,"character":20},"end":{"line":4,"character":23}}}]}]`
+	ranges, err := flattenSymbols(json.RawMessage(result))
+	if err != nil {
+		t.Fatalf("flattenSymbols error: %s", err)
+	}
+
+	tests := []struct {
+		pos  Position
+		want SymbolKind
+	}{
+		{Position{Line: 2, Character: 6}, SKClass},
+		{Position{Line: 4, Character: 20}, SKMethod},
+		{Position{Line: 12, Character: 0}, SKClass},
+		{Position{Line: 30, Character: 0}, 0},
+	}
+	for _, test := range tests {
+		if got := innermostSymbol(ranges, test.pos); got != test.want {
+			t.Errorf("Symbol at %+v expected kind %d, but got %d", test.pos, test.want, got)
+		}
+	}
+}
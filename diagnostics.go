@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// defaultDiagnosticsWait keeps the long poll below the HTTP time out.
+const defaultDiagnosticsWait = 15 * time.Second
+
+// diagnosticsStore keeps the latest published diagnostics of each document.
+// Every publish gets a new version, so pollers can tell whether they missed
+// an update.
+type diagnosticsStore struct {
+	version int
+	entries map[DocumentURI]*diagnosticsEntry
+	sync.Mutex
+}
+
+type diagnosticsEntry struct {
+	version     int
+	diagnostics []Diagnostic
+	// updated is closed on the next publish for the document
+	updated chan struct{}
+}
+
+type getDiagnosticsParams struct {
+	URI DocumentURI `json:"uri"`
+	// Sources to keep, all of them when empty
+	Sources []string `json:"sources,omitempty"`
+}
+
+type waitDiagnosticsParams struct {
+	URI DocumentURI `json:"uri"`
+	// Version of the diagnostics the editor has, 0 for none
+	Version int `json:"version,omitempty"`
+	// Timeout in milliseconds
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// entry returns the entry of the document, creating it if needed. Must be
+// called with the lock held.
+func (d *diagnosticsStore) entry(uri DocumentURI) *diagnosticsEntry {
+	if d.entries == nil {
+		d.entries = make(map[DocumentURI]*diagnosticsEntry)
+	}
+	e, ok := d.entries[uri]
+	if !ok {
+		e = &diagnosticsEntry{updated: make(chan struct{})}
+		d.entries[uri] = e
+	}
+	return e
+}
+
+// publish stores the diagnostics and wakes up the pollers of the document.
+func (d *diagnosticsStore) publish(uri DocumentURI, diagnostics []Diagnostic) {
+	d.Lock()
+	defer d.Unlock()
+	d.version++
+	e := d.entry(uri)
+	e.version = d.version
+	e.diagnostics = diagnostics
+	close(e.updated)
+	e.updated = make(chan struct{})
+}
+
+// since returns the diagnostics of the document if they are newer than
+// version, otherwise a channel closed on the next publish.
+func (d *diagnosticsStore) since(uri DocumentURI, version int) (int, []Diagnostic, <-chan struct{}) {
+	d.Lock()
+	defer d.Unlock()
+	e := d.entry(uri)
+	if e.version > version {
+		return e.version, e.diagnostics, nil
+	}
+	return e.version, nil, e.updated
+}
+
+// latest returns the stored diagnostics of the document.
+func (d *diagnosticsStore) latest(uri DocumentURI) (int, []Diagnostic) {
+	d.Lock()
+	defer d.Unlock()
+	e, ok := d.entries[uri]
+	if !ok {
+		return 0, nil
+	}
+	return e.version, e.diagnostics
+}
+
+// onWaitDiagnostics long polls the diagnostics of a document: it replies as
+// soon as there are diagnostics newer than the version the editor has.
+func (s *mateServer) onWaitDiagnostics(mr mateRequest, cb kvChan) {
+	params := waitDiagnosticsParams{}
+	if err := json.Unmarshal(mr.Body, &params); err != nil {
+		cb <- &KeyValue{"result": "error", "message": err.Error()}
+		return
+	}
+	if len(params.URI) == 0 {
+		cb <- &KeyValue{"result": "error", "message": "Invalid document uri"}
+		return
+	}
+	timeout := defaultDiagnosticsWait
+	if params.Timeout > 0 {
+		timeout = time.Duration(params.Timeout) * time.Millisecond
+	}
+	// an unchanged reply must beat the HTTP time out
+	timeout = mr.replyWithin(timeout)
+
+	version, diagnostics, updated := s.diagnostics.since(params.URI, params.Version)
+	if updated != nil {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-updated:
+			version, diagnostics, _ = s.diagnostics.since(params.URI, params.Version)
+		case <-timer.C:
+			cb <- &KeyValue{"result": KeyValue{"uri": params.URI, "version": version, "changed": false}}
+			return
+		}
+	}
+	diagnostics = filterDiagnostics(diagnostics, s.clientOptions(mr.Session).diagnosticSources)
+	cb <- &KeyValue{"result": KeyValue{"uri": params.URI, "version": version, "changed": true, "diagnostics": diagnostics}}
+}
+
+// onGetDiagnostics replies with the last diagnostics published for the
+// document, optionally narrowed to some sources.
+func (s *mateServer) onGetDiagnostics(mr mateRequest, cb kvChan) {
+	params := getDiagnosticsParams{}
+	if err := json.Unmarshal(mr.Body, &params); err != nil {
+		cb <- &KeyValue{"result": "error", "message": err.Error()}
+		return
+	}
+	version, diagnostics := s.diagnostics.latest(params.URI)
+	diagnostics = filterDiagnostics(diagnostics, s.clientOptions(mr.Session).diagnosticSources)
+	diagnostics = filterDiagnostics(diagnostics, sourceSet(params.Sources))
+	cb <- &KeyValue{"result": KeyValue{"uri": params.URI, "version": version, "diagnostics": diagnostics}}
+}
+
+// waitPublished waits for the next diagnostics of the document and replies
+// with the ones the session wants.
+func (s *mateServer) waitPublished(fn, session string, cb kvChan) {
+	reply := make(kvChan, 1)
+	s.wait("diagnostics."+fn, reply)
+	r := <-reply
+	if diagnostics, ok := (*r)["result"].([]Diagnostic); ok {
+		(*r)["result"] = filterDiagnostics(diagnostics, s.clientOptions(session).diagnosticSources)
+	}
+	cb <- r
+}
+
+// filterDiagnostics keeps the diagnostics from the given sources. All of them
+// are kept when sources is empty.
+func filterDiagnostics(diagnostics []Diagnostic, sources map[string]bool) []Diagnostic {
+	if len(sources) == 0 {
+		return diagnostics
+	}
+	filtered := make([]Diagnostic, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		if sources[d.Source] {
+			filtered = append(filtered, d)
+		}
+	}
+	return filtered
+}
+
+func sourceSet(sources []string) map[string]bool {
+	set := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		set[source] = true
+	}
+	return set
+}
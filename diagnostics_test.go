@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDiagnosticsStore_Since(t *testing.T) {
+	d := diagnosticsStore{}
+	uri := DocumentURI("file:///a.php")
+
+	version, _, updated := d.since(uri, 0)
+	if version != 0 || updated == nil {
+		t.Fatalf("Expected to wait for the first publish, got version %d", version)
+	}
+	go d.publish(uri, []Diagnostic{{Message: "first"}})
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("Publish didn't wake up the poller")
+	}
+
+	version, diagnostics, updated := d.since(uri, 0)
+	if updated != nil || len(diagnostics) != 1 || diagnostics[0].Message != "first" {
+		t.Errorf("Expected the first diagnostics, but got %+v", diagnostics)
+	}
+	if _, _, updated := d.since(uri, version); updated == nil {
+		t.Errorf("Expected to wait for diagnostics newer than version %d", version)
+	}
+	d.publish(DocumentURI("file:///b.php"), nil)
+	if _, _, updated := d.since(uri, version); updated == nil {
+		t.Errorf("Publish for another document updated version %d", version)
+	}
+}
+
+func TestFilterDiagnostics(t *testing.T) {
+	diagnostics := []Diagnostic{
+		{Source: "intelephense", Message: "a"},
+		{Source: "php", Message: "b"},
+		{Message: "c"},
+	}
+	if got := filterDiagnostics(diagnostics, nil); len(got) != 3 {
+		t.Errorf("Expected no filtering without sources, but got %+v", got)
+	}
+	got := filterDiagnostics(diagnostics, sourceSet([]string{"php"}))
+	if len(got) != 1 || got[0].Message != "b" {
+		t.Errorf("Expected only php diagnostics, but got %+v", got)
+	}
+}
+
+func TestOnWaitDiagnosticsTimeout(t *testing.T) {
+	s := mateServer{}
+	timeout := 200 * time.Millisecond
+	cb := make(kvChan, 1)
+	go s.onWaitDiagnostics(mateRequest{
+		Method:  "waitDiagnostics",
+		Body:    json.RawMessage(`{"uri":"file:///a.php","timeout":30000}`),
+		Timeout: timeout,
+	}, cb)
+
+	select {
+	case reply := <-cb:
+		result, ok := (*reply)["result"].(KeyValue)
+		if !ok || result["changed"] != false {
+			t.Errorf("Expected unchanged diagnostics, but got %v", *reply)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("Expected a reply before the request times out")
+	}
+}
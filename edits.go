@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+)
+
+func (s *mateServer) onRename(params RenameParams, cb kvChan) {
+	result, err := s.call("textDocument/rename", params)
+	s.recordRaw(cb, result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	if len(result) == 0 || string(result) == "null" {
+		cb <- &KeyValue{"result": nil}
+		return
+	}
+	edit := WorkspaceEdit{}
+	if err := json.Unmarshal(result, &edit); err != nil {
+		replyError(cb, err)
+		return
+	}
+	cb <- &KeyValue{"result": edit.Normalize()}
+}
+
+func (s *mateServer) onCodeAction(params CodeActionParams, cb kvChan) {
+	result, err := s.call("textDocument/codeAction", params)
+	s.recordRaw(cb, result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	actions, err := decodeCodeActions(result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	cb <- &KeyValue{"result": actions}
+}
+
+// decodeCodeActions parses a code action result, which mixes Commands and
+// CodeActions. Commands are wrapped into CodeActions and edits normalized.
+func decodeCodeActions(result json.RawMessage) ([]CodeAction, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(result, &raw); err != nil {
+		return nil, err
+	}
+	actions := make([]CodeAction, 0, len(raw))
+	for _, item := range raw {
+		probe := struct {
+			Command json.RawMessage `json:"command"`
+		}{}
+		if err := json.Unmarshal(item, &probe); err != nil {
+			return nil, err
+		}
+		// a Command has the command identifier as a string
+		if c := strings.TrimSpace(string(probe.Command)); len(c) > 0 && c[0] == '"' {
+			command := Command{}
+			if err := json.Unmarshal(item, &command); err != nil {
+				return nil, err
+			}
+			actions = append(actions, CodeAction{Title: command.Title, Command: &command})
+			continue
+		}
+		action := CodeAction{}
+		if err := json.Unmarshal(item, &action); err != nil {
+			return nil, err
+		}
+		if action.Edit != nil {
+			edit := action.Edit.Normalize()
+			action.Edit = &edit
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+func (s *mateServer) onExecuteCommand(params ExecuteCommandParams, cb kvChan) {
+	result, edits, err := s.executeCommand(params)
+	s.recordRaw(cb, result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	cb <- &KeyValue{"result": result, "edits": edits}
+}
+
+// executeCommand runs the command and returns the workspace edits the server
+// asked to apply while running it. Commands run one at a time, so the edits
+// can't get mixed up.
+func (s *mateServer) executeCommand(params ExecuteCommandParams) (json.RawMessage, []WorkspaceEdit, error) {
+	s.commandLock.Lock()
+	defer s.commandLock.Unlock()
+
+	s.cacheLock.Lock()
+	s.appliedEdits = []WorkspaceEdit{}
+	s.cacheLock.Unlock()
+
+	result, err := s.call("workspace/executeCommand", params)
+
+	s.cacheLock.Lock()
+	edits := s.appliedEdits
+	s.appliedEdits = nil
+	s.cacheLock.Unlock()
+
+	return result, edits, err
+}
+
+// onOrganizeImports runs the organize imports source action for the whole
+// document and returns the resulting text edits.
+func (s *mateServer) onOrganizeImports(textDocument TextDocumentIdentifier, cb kvChan) {
+	// documents that aren't open are covered by a range past any line
+	end := Position{Line: math.MaxInt32}
+	if doc, ok := s.document(textDocument.URI); ok {
+		lines := strings.Split(doc.Text, "\n")
+		end = Position{Line: len(lines) - 1, Character: len([]rune(lines[len(lines)-1]))}
+	}
+	result, err := s.call("textDocument/codeAction", CodeActionParams{
+		TextDocument: textDocument,
+		Range:        Range{End: end},
+		Context: CodeActionContext{
+			Diagnostics: []Diagnostic{},
+			Only:        []CodeActionKind{CAKSourceOrganizeImports},
+		},
+	})
+	s.recordRaw(cb, result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	actions, err := decodeCodeActions(result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+
+	edits := []TextEdit{}
+	for _, action := range actions {
+		// Commands come without a kind
+		if len(action.Kind) > 0 && !strings.HasPrefix(string(action.Kind), string(CAKSourceOrganizeImports)) {
+			continue
+		}
+		if action.Edit != nil {
+			edits = append(edits, documentEdits(*action.Edit, textDocument.URI)...)
+		}
+		if action.Command != nil {
+			_, applied, err := s.executeCommand(ExecuteCommandParams{
+				Command:   action.Command.Command,
+				Arguments: action.Command.Arguments,
+			})
+			if err != nil {
+				replyError(cb, err)
+				return
+			}
+			for _, edit := range applied {
+				edits = append(edits, documentEdits(edit, textDocument.URI)...)
+			}
+		}
+		break
+	}
+	cb <- &KeyValue{"result": edits}
+}
+
+// documentEdits returns the text edits of a normalized workspace edit that
+// apply to the document.
+func documentEdits(edit WorkspaceEdit, uri DocumentURI) []TextEdit {
+	var edits []TextEdit
+	for _, change := range edit.Normalize().DocumentChanges {
+		if change.TextDocumentEdit != nil && change.TextDocumentEdit.TextDocument.URI == uri {
+			edits = append(edits, change.TextDocumentEdit.Edits...)
+		}
+	}
+	return edits
+}
+
+// applyEdit collects a workspace/applyEdit request for the running command.
+// The editor applies the edits, so they are acknowledged as applied.
+func (s *mateServer) applyEdit(params KeyValue) ApplyWorkspaceEditResponse {
+	jsParams, _ := json.Marshal(params)
+	p := ApplyWorkspaceEditParams{}
+	if err := json.Unmarshal(jsParams, &p); err != nil {
+		Log.Warn(err)
+		return ApplyWorkspaceEditResponse{FailureReason: err.Error()}
+	}
+
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+	if s.appliedEdits == nil {
+		return ApplyWorkspaceEditResponse{FailureReason: "no command is running"}
+	}
+	s.appliedEdits = append(s.appliedEdits, p.Edit.Normalize())
+	return ApplyWorkspaceEditResponse{Applied: true}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// hoverSections is the hover split into parts the editor can style
+// separately.
+type hoverSections struct {
+	Signature     string `json:"signature"`
+	Documentation string `json:"documentation"`
+	Source        string `json:"source"`
+	Range         *Range `json:"range,omitempty"`
+}
+
+// sourceLink matches a paragraph that is only a location of the symbol: a
+// file uri or path, bare or as a markdown link.
+var sourceLink = regexp.MustCompile(`^(?:\[[^\]]*\]\()?(file://\S+|\S+\.php(?::\d+|#L\d+)?)\)?$`)
+
+// partialHoverTimeout bounds looking up the symbols for a preliminary hover.
+const partialHoverTimeout = 200 * time.Millisecond
+
+func (s *mateServer) onHover(params TextDocumentPositionParams, opts clientOptions, cb kvChan) {
+	result, raw, err := s.hover(params, opts)
+	s.recordRaw(cb, raw)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	cb <- &KeyValue{"result": result}
+}
+
+// hover requests the hover and returns it in the format the editor asked for,
+// along with the server result.
+func (s *mateServer) hover(params TextDocumentPositionParams, opts clientOptions) (interface{}, json.RawMessage, error) {
+	result, err := s.callFor(opts, "textDocument/hover", params)
+	if err != nil {
+		return nil, result, err
+	}
+	if len(result) == 0 || string(result) == "null" {
+		return nil, result, nil
+	}
+	hover := Hover{}
+	if err := json.Unmarshal(result, &hover); err != nil {
+		return nil, result, err
+	}
+	return formatHover(hover, opts), result, nil
+}
+
+func formatHover(h Hover, opts clientOptions) interface{} {
+	if opts.structuredHover {
+		return splitHover(h)
+	}
+	return h
+}
+
+// onPartialHover replies with a preliminary hover built from the document
+// symbols when the server is slow to answer, and sends the full hover to the
+// session as a "hover" event with the token once it arrives.
+func (s *mateServer) onPartialHover(mr mateRequest, params TextDocumentPositionParams, token string, cb kvChan) {
+	if len(token) == 0 {
+		token = s.newToken("hover")
+	}
+	opts := s.clientOptions(mr.Session)
+	type hoverReply struct {
+		result interface{}
+		raw    json.RawMessage
+		err    error
+	}
+	full := make(chan hoverReply, 1)
+	go func() {
+		result, raw, err := s.hover(params, opts)
+		full <- hoverReply{result, raw, err}
+	}()
+	preliminary := make(chan *Hover, 1)
+	go func() {
+		preliminary <- s.preliminaryHover(params)
+	}()
+
+	select {
+	case r := <-full:
+		s.recordRaw(cb, r.raw)
+		if r.err != nil {
+			replyError(cb, r.err)
+			return
+		}
+		cb <- &KeyValue{"result": r.result}
+		return
+	case h := <-preliminary:
+		if h == nil {
+			r := <-full
+			s.recordRaw(cb, r.raw)
+			if r.err != nil {
+				replyError(cb, r.err)
+				return
+			}
+			cb <- &KeyValue{"result": r.result}
+			return
+		}
+		cb <- &KeyValue{"result": formatHover(*h, opts), "partial": true, "token": token}
+	}
+
+	r := <-full
+	event := KeyValue{"token": token, "result": r.result}
+	if r.err != nil {
+		event = KeyValue{"token": token, "result": "error", "message": r.err.Error()}
+	}
+	s.stream.broadcastTo(map[string]bool{mr.Session: true}, "hover", event)
+}
+
+// preliminaryHover returns the signature of the document symbol named like
+// the word at the position, or nil if there is none or the server doesn't
+// list the symbols in time.
+func (s *mateServer) preliminaryHover(params TextDocumentPositionParams) *Hover {
+	doc, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		return nil
+	}
+	word := wordAt(doc.Text, params.Position)
+	if len(word) == 0 {
+		return nil
+	}
+	var found *symbolRange
+	for _, symbol := range s.symbolRanges(params.TextDocument.URI, partialHoverTimeout) {
+		if symbol.name != word && strings.TrimPrefix(symbol.name, "$") != strings.TrimPrefix(word, "$") {
+			continue
+		}
+		if found == nil || contains(symbol.selection, params.Position) {
+			symbol := symbol
+			found = &symbol
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	signature := found.detail
+	if len(signature) == 0 {
+		signature = found.name
+	}
+	return &Hover{Contents: []MarkedString{{Language: "php", Value: signature}}}
+}
+
+// wordAt returns the identifier at the position.
+func wordAt(text string, pos Position) string {
+	before := wordBefore(text, pos)
+	lines := strings.Split(text, "\n")
+	if pos.Line < 0 || pos.Line >= len(lines) {
+		return before
+	}
+	line := []rune(lines[pos.Line])
+	end := pos.Character
+	if end < 0 || end > len(line) {
+		return before
+	}
+	start := end
+	for end < len(line) && isWordChar(line[end]) && line[end] != '$' {
+		end++
+	}
+	return before + string(line[start:end])
+}
+
+// splitHover classifies the hover contents: code is the signature, a
+// paragraph pointing at a file is the source and the rest is documentation.
+func splitHover(h Hover) hoverSections {
+	var signature, documentation, source []string
+	for _, m := range h.Contents {
+		if !m.isRawString && len(m.Language) > 0 {
+			signature = append(signature, strings.TrimSpace(m.Value))
+			continue
+		}
+		code, prose := splitFencedCode(m.Value)
+		signature = append(signature, code...)
+		for _, paragraph := range prose {
+			if sourceLink.MatchString(paragraph) {
+				source = append(source, paragraph)
+			} else {
+				documentation = append(documentation, paragraph)
+			}
+		}
+	}
+	return hoverSections{
+		Signature:     strings.Join(signature, "\n"),
+		Documentation: strings.Join(documentation, "\n\n"),
+		Source:        strings.Join(source, "\n"),
+		Range:         h.Range,
+	}
+}
+
+// splitFencedCode separates the fenced code blocks of markdown text from the
+// paragraphs around them. The <?php opening tag is dropped from the code.
+func splitFencedCode(text string) (code []string, prose []string) {
+	var block []string
+	fenced := false
+	flush := func() {
+		if joined := strings.TrimSpace(strings.Join(block, "\n")); len(joined) > 0 {
+			if fenced {
+				code = append(code, joined)
+			} else {
+				prose = append(prose, joined)
+			}
+		}
+		block = block[:0]
+	}
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			fenced = !fenced
+		case fenced && trimmed == "<?php":
+		case !fenced && len(trimmed) == 0:
+			flush()
+		default:
+			block = append(block, line)
+		}
+	}
+	flush()
+	return code, prose
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSplitHover(t *testing.T) {
+	tests := []struct {
+		contents string
+		want     hoverSections
+	}{{
+		contents: `{"contents":[{"language":"php","value":"function foo(int $a): string"},"Does foo.","[foo.php](file:///src/foo.php)"]}`,
+		want: hoverSections{
+			Signature:     "function foo(int $a): string",
+			Documentation: "Does foo.",
+			Source:        "[foo.php](file:///src/foo.php)",
+		},
+	}, {
+		contents: "{\"contents\":{\"kind\":\"markdown\",\"value\":\"__Foo::bar__\\n\\n```php\\n<?php\\npublic function bar(): void { }\\n```\\n\\nFirst line.\\n\\n_@return_ `void`\\n\\n/src/Foo.php:12\"}}",
+		want: hoverSections{
+			Signature:     "public function bar(): void { }",
+			Documentation: "__Foo::bar__\n\nFirst line.\n\n_@return_ `void`",
+			Source:        "/src/Foo.php:12",
+		},
+	}, {
+		contents: `{"contents":"plain text only"}`,
+		want:     hoverSections{Documentation: "plain text only"},
+	}}
+
+	for _, test := range tests {
+		h := Hover{}
+		if err := json.Unmarshal([]byte(test.contents), &h); err != nil {
+			t.Errorf("json.Unmarshal error: %s", err)
+			continue
+		}
+		if got := splitHover(h); !reflect.DeepEqual(test.want, got) {
+			t.Errorf("Split %s, expected %+v, but got %+v", test.contents, test.want, got)
+		}
+	}
+}
+
+func TestWordAt(t *testing.T) {
+	text := "<?php\n$user->getName();\n"
+	tests := []struct {
+		pos  Position
+		want string
+	}{
+		{Position{Line: 1, Character: 2}, "$user"},
+		{Position{Line: 1, Character: 9}, "getName"},
+		{Position{Line: 1, Character: 14}, "getName"},
+		{Position{Line: 1, Character: 15}, ""},
+		{Position{Line: 5, Character: 0}, ""},
+	}
+
+	for _, test := range tests {
+		if got := wordAt(text, test.pos); got != test.want {
+			t.Errorf("Word at %+v expected %q, but got %q", test.pos, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// languageAliases maps the language ids and scopes editors send to the ids
+// language servers know.
+var languageAliases = map[string]string{
+	"phtml":         "php",
+	"source.php":    "php",
+	"text.html.php": "php",
+	"embedded.php":  "php",
+}
+
+// languageExtensions gives the language id of documents opened without one.
+var languageExtensions = map[string]string{
+	".php":   "php",
+	".phtml": "php",
+	".php5":  "php",
+	".php7":  "php",
+	".inc":   "php",
+}
+
+// languageID returns the id of the document language to send to the server,
+// and an error if the server doesn't support it. Documents opened without one
+// are taken for the main language of the server, unless their extension says
+// otherwise.
+func (p *lspClient) languageID(doc TextDocumentItem) (string, error) {
+	id := strings.ToLower(doc.LanguageID)
+	if alias, ok := languageAliases[id]; ok {
+		id = alias
+	}
+	if len(id) == 0 {
+		id = languageExtensions[strings.ToLower(path.Ext(string(doc.URI)))]
+	}
+	if len(id) == 0 && len(p.config.languages) > 0 {
+		id = p.config.languages[0]
+	}
+	for _, supported := range p.config.languages {
+		if id == supported {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("%s language is not supported by %s", id, p.config.url)
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestLanguageID(t *testing.T) {
+	client := lspClient{config: config{url: "intelephense", languages: []string{"php"}}}
+	tests := []struct {
+		doc  TextDocumentItem
+		want string
+		err  bool
+	}{
+		{TextDocumentItem{URI: "file:///a.php", LanguageID: "php"}, "php", false},
+		{TextDocumentItem{URI: "file:///a.phtml", LanguageID: "text.html.php"}, "php", false},
+		{TextDocumentItem{URI: "file:///a.phtml"}, "php", false},
+		{TextDocumentItem{URI: "file:///a.js", LanguageID: "javascript"}, "", true},
+		// no language id and an unknown extension fall back to the server language
+		{TextDocumentItem{URI: "file:///README"}, "php", false},
+		{TextDocumentItem{URI: "file:///views/index.tpl"}, "php", false},
+		{TextDocumentItem{URI: "file:///README", LanguageID: "markdown"}, "", true},
+	}
+
+	for _, test := range tests {
+		got, err := client.languageID(test.doc)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("languageID(%+v) expected %q (error %v), but got %q (%v)", test.doc, test.want, test.err, got, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	stdlog "log"
 	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh/terminal"
@@ -17,12 +18,17 @@ var (
 )
 
 var (
-	server   = flag.String("server", "intelephense", `server type (intelephense or phpls), default intelephense`)
-	logLevel = flag.String("level", "debug", `log level, default - debug`)
+	server       = flag.String("server", "intelephense", `server type (intelephense or phpls), default intelephense`)
+	logLevel     = flag.String("level", "debug", `log level, default - debug`)
+	ppid         = flag.Int("ppid", 0, `editor process id, the bridge exits when it's gone (-1 - parent process, default - disabled)`)
+	hangs        = flag.Int("hang-threshold", 5, `consecutive request time outs after which the language server is restarted (0 - disabled), default 5`)
+	initTimeout  = flag.Duration("init-timeout", 10*time.Second, `time to wait for the initialize response, default 10s`)
+	batchWorkers = flag.Int("batch-concurrency", 8, `requests of a batch sent to the language server at a time, default 8`)
+	idleTime     = flag.Duration("idle-timeout", 30*time.Minute, `open documents no request used for this long are closed (0 - never), default 30m`)
+	maxOpen      = flag.Int("max-open-files", 0, `open documents kept in the language server, the least recently used are closed (0 - unlimited), default 0`)
 )
 
 func init() {
-	flag.Parse()
 	stdlog.SetFlags(0)
 	stdlog.SetOutput(logrus.Writer())
 	logrus.SetReportCaller(true)
@@ -30,7 +36,6 @@ func init() {
 	ctx := context.Background()
 	Log = logrus.WithContext(ctx)
 
-	logrus.Level, _ = log.ParseLevel(*logLevel)
 	if terminal.IsTerminal(int(os.Stdout.Fd())) {
 		logrus.Formatter = &log.TextFormatter{ForceColors: false, FullTimestamp: true, TimestampFormat: "Jan 2 15:04:05", CallerPrettyfier: callerPrettyfier}
 	} else {
@@ -39,14 +44,18 @@ func init() {
 }
 
 func main() {
+	// parsed here rather than in init, test binaries have flags of their own
+	flag.Parse()
+	logrus.Level, _ = log.ParseLevel(*logLevel)
+
 	var client *lspClient
 	switch *server {
 	case "phpls":
-		client = newLspClient(config{true, "php", []string{userHomeDir() + "/.composer/vendor/felixfbecker/language-server/bin/php-language-server.php"}})
+		client = newLspClient(config{true, "php", []string{userHomeDir() + "/.composer/vendor/felixfbecker/language-server/bin/php-language-server.php"}, []string{"php"}})
 	case "intelephense":
 		fallthrough
 	default:
-		client = newLspClient(config{true, "intelephense", []string{"--stdio"}})
+		client = newLspClient(config{true, "intelephense", []string{"--stdio"}, []string{"php"}})
 	}
 	go runProfiler()
 	// start server and block
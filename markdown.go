@@ -0,0 +1,42 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// markdownRules turn markdown into plain text, in order.
+var markdownRules = []struct {
+	pattern *regexp.Regexp
+	replace string
+}{
+	{regexp.MustCompile("(?m)^[ \t]*```.*$\\n?"), ""},
+	{regexp.MustCompile(`(?m)^[ \t]*(?:-{3,}|\*{3,}|_{3,})[ \t]*$`), ""},
+	{regexp.MustCompile(`(?m)^#{1,6}[ \t]+`), ""},
+	{regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`), "$1"},
+	{regexp.MustCompile("`([^`]*)`"), "$1"},
+	{regexp.MustCompile(`\*\*(.+?)\*\*`), "$1"},
+	{regexp.MustCompile(`__(.+?)__`), "$1"},
+	{regexp.MustCompile(`\*(\S(?:[^*]*\S)?)\*`), "$1"},
+	{regexp.MustCompile(`(^|\W)_(\S(?:[^_]*\S)?)_(\W|$)`), "$1$2$3"},
+	{regexp.MustCompile(`\n{3,}`), "\n\n"},
+	{regexp.MustCompile(`\\([\\` + "`" + `*_{}\[\]()#+\-.!|])`), "$1"},
+}
+
+// stripMarkdown returns the text of markdown without its formatting.
+func stripMarkdown(markdown string) string {
+	text := markdown
+	for _, rule := range markdownRules {
+		text = rule.pattern.ReplaceAllString(text, rule.replace)
+	}
+	return strings.TrimSpace(text)
+}
+
+// plainDocumentation converts markdown documentation of the item to plain
+// text, for servers ignoring the advertised format preference.
+func plainDocumentation(item *CompletionItem) {
+	if item.Documentation == nil || item.Documentation.Kind != MKMarkdown {
+		return
+	}
+	item.Documentation = &MarkupContent{Kind: MKPlainText, Value: stripMarkdown(item.Documentation.Value)}
+}
@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestStripMarkdown(t *testing.T) {
+	tests := []struct {
+		markdown string
+		want     string
+	}{
+		{"__Foo::bar__\n\n```php\n<?php\npublic function bar(): void { }\n```", "Foo::bar\n\n<?php\npublic function bar(): void { }"},
+		{"_@param_ `int` **$count** the *number* of items", "@param int $count the number of items"},
+		{"See [the docs](https://php.net/strlen).", "See the docs."},
+		{"# Title\n\n---\n\nsnake_case_name stays", "Title\n\nsnake_case_name stays"},
+		{`\\Foo\\Bar and 2 \* 3`, `\Foo\Bar and 2 * 3`},
+	}
+
+	for _, test := range tests {
+		if got := stripMarkdown(test.markdown); got != test.want {
+			t.Errorf("Stripped %q, expected %q, but got %q", test.markdown, test.want, got)
+		}
+	}
+}
@@ -69,12 +69,49 @@ func (r notification) getMethod() string {
 	return r.method
 }
 
+// Error codes defined by JSON-RPC and the language server protocol.
+const (
+	ParseError           = -32700
+	InvalidRequest       = -32600
+	MethodNotFound       = -32601
+	InvalidParams        = -32602
+	InternalError        = -32603
+	ServerNotInitialized = -32002
+	UnknownErrorCode     = -32001
+	RequestCancelled     = -32800
+	ContentModified      = -32801
+
+	// RequestTimedOut is reported by the bridge when the server didn't answer
+	// a request in time.
+	RequestTimedOut = -32000
+	// ServerRestarted is reported by the bridge for requests pending when the
+	// language server crashed.
+	ServerRestarted = -32003
+)
+
+// LSPError is the error object of a JSON-RPC response.
+type LSPError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *LSPError) Error() string {
+	return e.Message
+}
+
+// isErrorCode reports whether err is a language server error with the code.
+func isErrorCode(err error, code int) bool {
+	lspErr, ok := err.(*LSPError)
+	return ok && lspErr.Code == code
+}
+
 type response struct {
 	ID     int
 	Method string
 	Params KeyValue
 	Result json.RawMessage
-	Error  KeyValue
+	Error  *LSPError
 }
 
 func (r *response) getBody() KeyValue {
@@ -4,6 +4,8 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -24,16 +26,45 @@ func (kv KeyValue) bool(name string, defaultValue bool) bool {
 }
 
 // int returns the value of the given name, assuming the value is an int.
+// Numbers decoded from JSON are accepted as well.
 // If the value isn't found or is not of the type, the defaultValue is returned.
 func (kv KeyValue) int(name string, defaultValue int) int {
 	if v, found := kv[name]; found {
-		if castValue, is := v.(int); is {
+		switch castValue := v.(type) {
+		case int:
 			return castValue
+		case float64:
+			return int(castValue)
 		}
 	}
 	return defaultValue
 }
 
+// ints returns the value of the given name, assuming the value is a list of
+// numbers. If the value isn't found or is not of the type, the defaultValue is
+// returned.
+func (kv KeyValue) ints(name string, defaultValue []int) []int {
+	v, found := kv[name]
+	if !found {
+		return defaultValue
+	}
+	switch castValue := v.(type) {
+	case []int:
+		return castValue
+	case []interface{}:
+		values := make([]int, 0, len(castValue))
+		for _, item := range castValue {
+			number, is := item.(float64)
+			if !is {
+				return defaultValue
+			}
+			values = append(values, int(number))
+		}
+		return values
+	}
+	return defaultValue
+}
+
 // string returns the value of the given name, assuming the value is a string.
 // If the value isn't found or is not of the type, the defaultValue is returned.
 func (kv KeyValue) string(name string, defaultValue string) string {
@@ -45,6 +76,31 @@ func (kv KeyValue) string(name string, defaultValue string) string {
 	return defaultValue
 }
 
+// strings returns the value of the given name, assuming the value is a list of
+// strings. If the value isn't found or is not of the type, the defaultValue is
+// returned.
+func (kv KeyValue) strings(name string, defaultValue []string) []string {
+	v, found := kv[name]
+	if !found {
+		return defaultValue
+	}
+	switch castValue := v.(type) {
+	case []string:
+		return castValue
+	case []interface{}:
+		values := make([]string, 0, len(castValue))
+		for _, item := range castValue {
+			str, is := item.(string)
+			if !is {
+				return defaultValue
+			}
+			values = append(values, str)
+		}
+		return values
+	}
+	return defaultValue
+}
+
 // float64 returns the value of the given name, assuming the value is a float64.
 // If the value isn't found or is not of the type, the defaultValue is returned.
 func (kv KeyValue) float64(name string, defaultValue float64) float64 {
@@ -244,6 +300,39 @@ func (p *InitializeParams) Root() DocumentURI {
 	return DocumentURI("file://" + p.RootPath)
 }
 
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+}
+
+// ServerCapabilities holds the capabilities of the language server the
+// bridge makes use of.
+type ServerCapabilities struct {
+	CompletionProvider     *CompletionOptions     `json:"completionProvider,omitempty"`
+	SignatureHelpProvider  *SignatureHelpOptions  `json:"signatureHelpProvider,omitempty"`
+	ExecuteCommandProvider *ExecuteCommandOptions `json:"executeCommandProvider,omitempty"`
+
+	/**
+	 * Either a boolean or CodeActionOptions.
+	 */
+	CodeActionProvider json.RawMessage `json:"codeActionProvider,omitempty"`
+}
+
+// CodeActionOptions returns the code action options of the server and
+// whether it provides code actions at all.
+func (c ServerCapabilities) CodeActionOptions() (CodeActionOptions, bool) {
+	options := CodeActionOptions{}
+	provider := strings.TrimSpace(string(c.CodeActionProvider))
+	if len(provider) == 0 || provider == "false" || provider == "null" {
+		return options, false
+	}
+	if provider[0] == '{' {
+		if err := json.Unmarshal(c.CodeActionProvider, &options); err != nil {
+			return options, false
+		}
+	}
+	return options, true
+}
+
 type CompletionOptions struct {
 	ResolveProvider   bool     `json:"resolveProvider,omitempty"`
 	TriggerCharacters []string `json:"triggerCharacters,omitempty"`
@@ -258,7 +347,12 @@ type CodeLensOptions struct {
 }
 
 type SignatureHelpOptions struct {
-	TriggerCharacters []string `json:"triggerCharacters,omitempty"`
+	TriggerCharacters   []string `json:"triggerCharacters,omitempty"`
+	RetriggerCharacters []string `json:"retriggerCharacters,omitempty"`
+}
+
+type CancelParams struct {
+	ID interface{} `json:"id"`
 }
 
 type ExecuteCommandOptions struct {
@@ -334,16 +428,77 @@ var completionItemKindName = map[CompletionItemKind]string{
 }
 
 type CompletionItem struct {
-	Label            string             `json:"label"`
-	Kind             CompletionItemKind `json:"kind,omitempty"`
-	Detail           string             `json:"detail,omitempty"`
-	Documentation    string             `json:"documentation,omitempty"`
-	SortText         string             `json:"sortText,omitempty"`
-	FilterText       string             `json:"filterText,omitempty"`
-	InsertText       string             `json:"insertText,omitempty"`
-	InsertTextFormat InsertTextFormat   `json:"insertTextFormat,omitempty"`
-	TextEdit         *TextEdit          `json:"textEdit,omitempty"`
-	Data             interface{}        `json:"data,omitempty"`
+	Label               string             `json:"label"`
+	Kind                CompletionItemKind `json:"kind,omitempty"`
+	Detail              string             `json:"detail,omitempty"`
+	Documentation       *MarkupContent     `json:"documentation,omitempty"`
+	Deprecated          bool               `json:"deprecated,omitempty"`
+	Preselect           bool               `json:"preselect,omitempty"`
+	SortText            string             `json:"sortText,omitempty"`
+	FilterText          string             `json:"filterText,omitempty"`
+	InsertText          string             `json:"insertText,omitempty"`
+	InsertTextFormat    InsertTextFormat   `json:"insertTextFormat,omitempty"`
+	TextEdit            *TextEdit          `json:"textEdit,omitempty"`
+	AdditionalTextEdits []TextEdit         `json:"additionalTextEdits,omitempty"`
+	CommitCharacters    []string           `json:"commitCharacters,omitempty"`
+	Command             *Command           `json:"command,omitempty"`
+	Data                interface{}        `json:"data,omitempty"`
+	// extra keeps the fields the model doesn't know, so they reach the editor
+	extra map[string]json.RawMessage
+}
+
+type completionItem CompletionItem
+
+// completionItemFields are the JSON names of the known CompletionItem fields.
+var completionItemFields = jsonFieldNames(reflect.TypeOf(CompletionItem{}))
+
+func (item *CompletionItem) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*completionItem)(item)); err != nil {
+		return err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for name := range fields {
+		if completionItemFields[name] {
+			delete(fields, name)
+		}
+	}
+	item.extra = nil
+	if len(fields) > 0 {
+		item.extra = fields
+	}
+	return nil
+}
+
+func (item CompletionItem) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(completionItem(item))
+	if err != nil || len(item.extra) == 0 {
+		return data, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range item.extra {
+		if _, ok := fields[name]; !ok {
+			fields[name] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// jsonFieldNames returns the JSON names of the fields of the struct type.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if len(name) > 0 && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
 }
 
 type CompletionList struct {
@@ -354,8 +509,9 @@ type CompletionList struct {
 type CompletionTriggerKind int
 
 const (
-	CTKInvoked          CompletionTriggerKind = 1
-	CTKTriggerCharacter                       = 2
+	CTKInvoked                         CompletionTriggerKind = 1
+	CTKTriggerCharacter                                      = 2
+	CTKTriggerForIncompleteCompletions                       = 3
 )
 
 type InsertTextFormat int
@@ -375,6 +531,45 @@ type CompletionParams struct {
 	Context CompletionContext `json:"context,omitempty"`
 }
 
+type MarkupKind string
+
+const (
+	MKPlainText MarkupKind = "plaintext"
+	MKMarkdown  MarkupKind = "markdown"
+)
+
+// MarkupContent is a string value whose content is interpreted based on its
+// kind. Older servers send a bare string instead, which is read as plaintext
+// and written back as a string.
+type MarkupContent markupContent
+
+type markupContent struct {
+	Kind  MarkupKind `json:"kind"`
+	Value string     `json:"value"`
+	// bare is set when the content was sent as a bare string
+	bare bool
+}
+
+func (m MarkupContent) MarshalJSON() ([]byte, error) {
+	if m.bare {
+		return json.Marshal(m.Value)
+	}
+	return json.Marshal(markupContent(m))
+}
+
+func (m *MarkupContent) UnmarshalJSON(data []byte) error {
+	if d := strings.TrimSpace(string(data)); len(d) > 0 && d[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*m = MarkupContent{Kind: MKPlainText, Value: s, bare: true}
+		return nil
+	}
+	*m = MarkupContent{}
+	return json.Unmarshal(data, (*markupContent)(m))
+}
+
 type Hover struct {
 	Contents []MarkedString `json:"contents"`
 	Range    *Range         `json:"range,omitempty"`
@@ -382,6 +577,48 @@ type Hover struct {
 
 type hover Hover
 
+// UnmarshalJSON accepts all the shapes of hover contents: a MarkedString
+// array, a single MarkedString or a MarkupContent object. Contents are always
+// normalized to a MarkedString array.
+func (h *Hover) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		Contents json.RawMessage `json:"contents"`
+		Range    *Range          `json:"range,omitempty"`
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	h.Contents = nil
+	h.Range = raw.Range
+
+	contents := strings.TrimSpace(string(raw.Contents))
+	if len(contents) == 0 || contents == "null" {
+		return nil
+	}
+	if contents[0] == '[' {
+		return json.Unmarshal(raw.Contents, &h.Contents)
+	}
+	if contents[0] == '{' {
+		markup := struct {
+			Kind  *MarkupKind `json:"kind"`
+			Value string      `json:"value"`
+		}{}
+		if err := json.Unmarshal(raw.Contents, &markup); err != nil {
+			return err
+		}
+		if markup.Kind != nil {
+			h.Contents = []MarkedString{RawMarkedString(markup.Value)}
+			return nil
+		}
+	}
+	var m MarkedString
+	if err := json.Unmarshal(raw.Contents, &m); err != nil {
+		return err
+	}
+	h.Contents = []MarkedString{m}
+	return nil
+}
+
 func (h Hover) MarshalJSON() ([]byte, error) {
 	if h.Contents == nil {
 		return json.Marshal(hover{
@@ -456,6 +693,7 @@ type ReferenceContext struct {
 
 type ReferenceParams struct {
 	TextDocumentPositionParams
+	WorkDoneProgressParams
 	Context ReferenceContext `json:"context"`
 }
 
@@ -548,9 +786,35 @@ type SymbolInformation struct {
 	ContainerName string     `json:"containerName,omitempty"`
 }
 
+// DocumentSymbol is a symbol of a document with the symbols it contains.
+type DocumentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           SymbolKind       `json:"kind"`
+	Deprecated     bool             `json:"deprecated,omitempty"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+// LocationLink is a definition target along with the range it was found from.
+type LocationLink struct {
+	OriginSelectionRange *Range      `json:"originSelectionRange,omitempty"`
+	TargetURI            DocumentURI `json:"targetUri"`
+	TargetRange          Range       `json:"targetRange"`
+	TargetSelectionRange Range       `json:"targetSelectionRange"`
+}
+
 type WorkspaceSymbolParams struct {
 	Query string `json:"query"`
 	Limit int    `json:"limit"`
+	WorkDoneProgressParams
+}
+
+// WorkDoneProgressParams lets the server report progress of the request
+// under the token.
+type WorkDoneProgressParams struct {
+	WorkDoneToken interface{} `json:"workDoneToken,omitempty"`
 }
 
 type ConfigurationParams struct {
@@ -564,8 +828,29 @@ type ConfigurationItem struct {
 
 type ConfigurationResult []interface{}
 
+type CodeActionKind string
+
+const (
+	CAKQuickFix              CodeActionKind = "quickfix"
+	CAKRefactor              CodeActionKind = "refactor"
+	CAKRefactorExtract       CodeActionKind = "refactor.extract"
+	CAKRefactorInline        CodeActionKind = "refactor.inline"
+	CAKRefactorRewrite       CodeActionKind = "refactor.rewrite"
+	CAKSource                CodeActionKind = "source"
+	CAKSourceOrganizeImports CodeActionKind = "source.organizeImports"
+)
+
 type CodeActionContext struct {
 	Diagnostics []Diagnostic `json:"diagnostics"`
+
+	/**
+	 * Requested kinds of actions to return, all of them if omitted.
+	 */
+	Only []CodeActionKind `json:"only,omitempty"`
+}
+
+type CodeActionOptions struct {
+	CodeActionKinds []CodeActionKind `json:"codeActionKinds,omitempty"`
 }
 
 type CodeActionParams struct {
@@ -574,6 +859,15 @@ type CodeActionParams struct {
 	Context      CodeActionContext      `json:"context"`
 }
 
+type CodeAction struct {
+	Title       string         `json:"title"`
+	Kind        CodeActionKind `json:"kind,omitempty"`
+	Diagnostics []Diagnostic   `json:"diagnostics,omitempty"`
+	IsPreferred bool           `json:"isPreferred,omitempty"`
+	Edit        *WorkspaceEdit `json:"edit,omitempty"`
+	Command     *Command       `json:"command,omitempty"`
+}
+
 type CodeLensParams struct {
 	TextDocument TextDocumentIdentifier `json:"textDocument"`
 }
@@ -601,6 +895,160 @@ type RenameParams struct {
 	NewName      string                 `json:"newName"`
 }
 
+type OptionalVersionedTextDocumentIdentifier struct {
+	TextDocumentIdentifier
+	/**
+	 * The version number of this document, null if the edit doesn't depend
+	 * on a particular version.
+	 */
+	Version *int `json:"version"`
+}
+
+type TextDocumentEdit struct {
+	/**
+	 * The text document to change.
+	 */
+	TextDocument OptionalVersionedTextDocumentIdentifier `json:"textDocument"`
+
+	/**
+	 * The edits to be applied.
+	 */
+	Edits []TextEdit `json:"edits"`
+}
+
+type CreateFileOptions struct {
+	Overwrite      bool `json:"overwrite,omitempty"`
+	IgnoreIfExists bool `json:"ignoreIfExists,omitempty"`
+}
+
+type CreateFile struct {
+	Kind    string             `json:"kind"`
+	URI     DocumentURI        `json:"uri"`
+	Options *CreateFileOptions `json:"options,omitempty"`
+}
+
+type RenameFileOptions struct {
+	Overwrite      bool `json:"overwrite,omitempty"`
+	IgnoreIfExists bool `json:"ignoreIfExists,omitempty"`
+}
+
+type RenameFile struct {
+	Kind    string             `json:"kind"`
+	OldURI  DocumentURI        `json:"oldUri"`
+	NewURI  DocumentURI        `json:"newUri"`
+	Options *RenameFileOptions `json:"options,omitempty"`
+}
+
+type DeleteFileOptions struct {
+	Recursive         bool `json:"recursive,omitempty"`
+	IgnoreIfNotExists bool `json:"ignoreIfNotExists,omitempty"`
+}
+
+type DeleteFile struct {
+	Kind    string             `json:"kind"`
+	URI     DocumentURI        `json:"uri"`
+	Options *DeleteFileOptions `json:"options,omitempty"`
+}
+
+// DocumentChange is an entry of WorkspaceEdit.DocumentChanges: either a text
+// document edit or a create, rename or delete file operation. Exactly one of
+// the fields is set.
+type DocumentChange struct {
+	TextDocumentEdit *TextDocumentEdit
+	CreateFile       *CreateFile
+	RenameFile       *RenameFile
+	DeleteFile       *DeleteFile
+}
+
+func (c *DocumentChange) UnmarshalJSON(data []byte) error {
+	probe := struct {
+		Kind string `json:"kind"`
+	}{}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+	*c = DocumentChange{}
+	switch probe.Kind {
+	case "create":
+		c.CreateFile = &CreateFile{}
+		return json.Unmarshal(data, c.CreateFile)
+	case "rename":
+		c.RenameFile = &RenameFile{}
+		return json.Unmarshal(data, c.RenameFile)
+	case "delete":
+		c.DeleteFile = &DeleteFile{}
+		return json.Unmarshal(data, c.DeleteFile)
+	case "":
+		c.TextDocumentEdit = &TextDocumentEdit{}
+		return json.Unmarshal(data, c.TextDocumentEdit)
+	}
+	return fmt.Errorf("unknown resource operation kind: %q", probe.Kind)
+}
+
+func (c DocumentChange) MarshalJSON() ([]byte, error) {
+	switch {
+	case c.CreateFile != nil:
+		return json.Marshal(c.CreateFile)
+	case c.RenameFile != nil:
+		return json.Marshal(c.RenameFile)
+	case c.DeleteFile != nil:
+		return json.Marshal(c.DeleteFile)
+	}
+	return json.Marshal(c.TextDocumentEdit)
+}
+
+type WorkspaceEdit struct {
+	/**
+	 * Holds changes to existing resources.
+	 */
+	Changes map[DocumentURI][]TextEdit `json:"changes,omitempty"`
+
+	/**
+	 * Versioned document changes and resource operations, applied in order.
+	 * Preferred over changes by servers supporting them.
+	 */
+	DocumentChanges []DocumentChange `json:"documentChanges,omitempty"`
+}
+
+// Normalize returns the edit with all changes expressed as document changes,
+// so the editor has to handle only one form. Document changes take precedence
+// over changes when a server sends both.
+func (e WorkspaceEdit) Normalize() WorkspaceEdit {
+	normalized := WorkspaceEdit{DocumentChanges: e.DocumentChanges}
+	if len(e.DocumentChanges) > 0 {
+		return normalized
+	}
+	uris := make([]string, 0, len(e.Changes))
+	for uri := range e.Changes {
+		uris = append(uris, string(uri))
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		normalized.DocumentChanges = append(normalized.DocumentChanges, DocumentChange{
+			TextDocumentEdit: &TextDocumentEdit{
+				TextDocument: OptionalVersionedTextDocumentIdentifier{
+					TextDocumentIdentifier: TextDocumentIdentifier{DocumentURI(uri)},
+				},
+				Edits: e.Changes[DocumentURI(uri)],
+			},
+		})
+	}
+	if normalized.DocumentChanges == nil {
+		normalized.DocumentChanges = []DocumentChange{}
+	}
+	return normalized
+}
+
+type ApplyWorkspaceEditParams struct {
+	Label string        `json:"label,omitempty"`
+	Edit  WorkspaceEdit `json:"edit"`
+}
+
+type ApplyWorkspaceEditResponse struct {
+	Applied       bool   `json:"applied"`
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
 type DidOpenTextDocumentParams struct {
 	TextDocument TextDocumentItem `json:"textDocument"`
 }
@@ -56,6 +56,27 @@ func TestHover(t *testing.T) {
 		data:          []byte(`{"contents":[]}`),
 		want:          Hover{Contents: nil},
 		skipUnmarshal: true, // testing we don't marshal nil
+	}, {
+		data:        []byte(`{"contents":{"language":"php","value":"function foo()"}}`),
+		want:        Hover{Contents: []MarkedString{{Language: "php", Value: "function foo()", isRawString: false}}},
+		skipMarshal: true, // a single MarkedString is normalized to an array
+	}, {
+		data:        []byte(`{"contents":"foo"}`),
+		want:        Hover{Contents: []MarkedString{{Value: "foo", isRawString: true}}},
+		skipMarshal: true,
+	}, {
+		data: []byte(`{"contents":{"kind":"markdown","value":"__foo__"},"range":{"start":{"line":1,"character":2},"end":{"line":1,"character":5}}}`),
+		want: Hover{
+			Contents: []MarkedString{{Value: "__foo__", isRawString: true}},
+			Range:    &Range{Start: Position{1, 2}, End: Position{1, 5}},
+		},
+		skipMarshal: true, // MarkupContent is normalized to a MarkedString array
+	}, {
+		data: []byte(`{"contents":["foo",{"language":"php","value":"bar"}]}`),
+		want: Hover{Contents: []MarkedString{
+			{Value: "foo", isRawString: true},
+			{Language: "php", Value: "bar", isRawString: false},
+		}},
 	}}
 
 	for _, test := range tests {
@@ -83,3 +104,176 @@ func TestHover(t *testing.T) {
 		}
 	}
 }
+
+func TestWorkspaceEdit_Normalize(t *testing.T) {
+	version := 3
+	tests := []struct {
+		data []byte
+		want WorkspaceEdit
+	}{{
+		data: []byte(`{"changes":{"file:///b.php":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":3}},"newText":"Bar"}],"file:///a.php":[]}}`),
+		want: WorkspaceEdit{DocumentChanges: []DocumentChange{{
+			TextDocumentEdit: &TextDocumentEdit{
+				TextDocument: OptionalVersionedTextDocumentIdentifier{TextDocumentIdentifier: TextDocumentIdentifier{"file:///a.php"}},
+				Edits:        []TextEdit{},
+			},
+		}, {
+			TextDocumentEdit: &TextDocumentEdit{
+				TextDocument: OptionalVersionedTextDocumentIdentifier{TextDocumentIdentifier: TextDocumentIdentifier{"file:///b.php"}},
+				Edits:        []TextEdit{{Range: Range{End: Position{0, 3}}, NewText: "Bar"}},
+			},
+		}}},
+	}, {
+		data: []byte(`{"documentChanges":[{"textDocument":{"uri":"file:///a.php","version":3},"edits":[{"range":{"start":{"line":1,"character":0},"end":{"line":1,"character":0}},"newText":"use Foo;\n"}]}]}`),
+		want: WorkspaceEdit{DocumentChanges: []DocumentChange{{
+			TextDocumentEdit: &TextDocumentEdit{
+				TextDocument: OptionalVersionedTextDocumentIdentifier{TextDocumentIdentifier: TextDocumentIdentifier{"file:///a.php"}, Version: &version},
+				Edits:        []TextEdit{{Range: Range{Start: Position{1, 0}, End: Position{1, 0}}, NewText: "use Foo;\n"}},
+			},
+		}}},
+	}, {
+		data: []byte(`{"changes":{"file:///a.php":[{"range":{"start":{"line":1,"character":0},"end":{"line":1,"character":0}},"newText":"use Foo;\n"}]},"documentChanges":[{"textDocument":{"uri":"file:///a.php","version":3},"edits":[{"range":{"start":{"line":1,"character":0},"end":{"line":1,"character":0}},"newText":"use Foo;\n"}]}]}`),
+		want: WorkspaceEdit{DocumentChanges: []DocumentChange{{
+			TextDocumentEdit: &TextDocumentEdit{
+				TextDocument: OptionalVersionedTextDocumentIdentifier{TextDocumentIdentifier: TextDocumentIdentifier{"file:///a.php"}, Version: &version},
+				Edits:        []TextEdit{{Range: Range{Start: Position{1, 0}, End: Position{1, 0}}, NewText: "use Foo;\n"}},
+			},
+		}}},
+	}, {
+		data: []byte(`{}`),
+		want: WorkspaceEdit{DocumentChanges: []DocumentChange{}},
+	}}
+
+	for _, test := range tests {
+		var e WorkspaceEdit
+		if err := json.Unmarshal(test.data, &e); err != nil {
+			t.Errorf("json.Unmarshal error: %s", err)
+			continue
+		}
+		if got := e.Normalize(); !reflect.DeepEqual(test.want, got) {
+			t.Errorf("Normalized %q, expected %+v, but got %+v", string(test.data), test.want, got)
+		}
+	}
+}
+
+func TestDocumentChange_MarshalUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want DocumentChange
+	}{{
+		data: []byte(`{"kind":"create","uri":"file:///src/Bar.php","options":{"ignoreIfExists":true}}`),
+		want: DocumentChange{CreateFile: &CreateFile{Kind: "create", URI: "file:///src/Bar.php", Options: &CreateFileOptions{IgnoreIfExists: true}}},
+	}, {
+		data: []byte(`{"kind":"rename","oldUri":"file:///src/Foo.php","newUri":"file:///src/Bar.php"}`),
+		want: DocumentChange{RenameFile: &RenameFile{Kind: "rename", OldURI: "file:///src/Foo.php", NewURI: "file:///src/Bar.php"}},
+	}, {
+		data: []byte(`{"kind":"delete","uri":"file:///src/Foo.php"}`),
+		want: DocumentChange{DeleteFile: &DeleteFile{Kind: "delete", URI: "file:///src/Foo.php"}},
+	}, {
+		data: []byte(`{"textDocument":{"uri":"file:///src/Bar.php","version":null},"edits":[]}`),
+		want: DocumentChange{TextDocumentEdit: &TextDocumentEdit{
+			TextDocument: OptionalVersionedTextDocumentIdentifier{TextDocumentIdentifier: TextDocumentIdentifier{"file:///src/Bar.php"}},
+			Edits:        []TextEdit{},
+		}},
+	}}
+
+	for _, test := range tests {
+		var c DocumentChange
+		if err := json.Unmarshal(test.data, &c); err != nil {
+			t.Errorf("json.Unmarshal error: %s", err)
+			continue
+		}
+		if !reflect.DeepEqual(test.want, c) {
+			t.Errorf("Unmarshaled %q, expected %+v, but got %+v", string(test.data), test.want, c)
+			continue
+		}
+
+		marshaled, err := json.Marshal(c)
+		if err != nil {
+			t.Errorf("json.Marshal error: %s", err)
+			continue
+		}
+		if string(marshaled) != string(test.data) {
+			t.Errorf("Marshaled result expected %s, but got %s", string(test.data), string(marshaled))
+		}
+	}
+}
+
+func TestCompletionItem_MarshalUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want CompletionItem
+	}{{
+		data: []byte(`{"label":"strlen","documentation":{"kind":"markdown","value":"**strlen**"}}`),
+		want: CompletionItem{Label: "strlen", Documentation: &MarkupContent{Kind: MKMarkdown, Value: "**strlen**"}},
+	}, {
+		data: []byte(`{"insertTextMode":2,"kind":3,"label":"strlen","labelDetails":{"detail":"(string $string)"},"tags":[1]}`),
+		want: CompletionItem{
+			Label: "strlen",
+			Kind:  CIKFunction,
+			extra: map[string]json.RawMessage{
+				"insertTextMode": json.RawMessage(`2`),
+				"labelDetails":   json.RawMessage(`{"detail":"(string $string)"}`),
+				"tags":           json.RawMessage(`[1]`),
+			},
+		},
+	}}
+
+	for _, test := range tests {
+		var item CompletionItem
+		if err := json.Unmarshal(test.data, &item); err != nil {
+			t.Errorf("json.Unmarshal error: %s", err)
+			continue
+		}
+		if !reflect.DeepEqual(test.want, item) {
+			t.Errorf("Unmarshaled %q, expected %+v, but got %+v", string(test.data), test.want, item)
+			continue
+		}
+
+		marshaled, err := json.Marshal(item)
+		if err != nil {
+			t.Errorf("json.Marshal error: %s", err)
+			continue
+		}
+		if string(marshaled) != string(test.data) {
+			t.Errorf("Marshaled result expected %s, but got %s", string(test.data), string(marshaled))
+		}
+	}
+}
+
+func TestMarkupContent_MarshalUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want MarkupContent
+	}{{
+		data: []byte(`{"kind":"markdown","value":"**strlen**"}`),
+		want: MarkupContent{Kind: MKMarkdown, Value: "**strlen**"},
+	}, {
+		data: []byte(`"Returns the length."`),
+		want: MarkupContent{Kind: MKPlainText, Value: "Returns the length.", bare: true},
+	}, {
+		data: []byte(`""`),
+		want: MarkupContent{Kind: MKPlainText, bare: true},
+	}}
+
+	for _, test := range tests {
+		var m MarkupContent
+		if err := json.Unmarshal(test.data, &m); err != nil {
+			t.Errorf("json.Unmarshal error: %s", err)
+			continue
+		}
+		if !reflect.DeepEqual(test.want, m) {
+			t.Errorf("Unmarshaled %q, expected %+v, but got %+v", string(test.data), test.want, m)
+			continue
+		}
+
+		marshaled, err := json.Marshal(m)
+		if err != nil {
+			t.Errorf("json.Marshal error: %s", err)
+			continue
+		}
+		if string(marshaled) != string(test.data) {
+			t.Errorf("Marshaled result expected %s, but got %s", string(test.data), string(marshaled))
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// requestURI returns the uri of the document the request is about, if any.
+func requestURI(body json.RawMessage) DocumentURI {
+	params := struct {
+		TextDocument TextDocumentIdentifier `json:"textDocument"`
+		URI          DocumentURI            `json:"uri"`
+	}{}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return ""
+	}
+	if len(params.TextDocument.URI) > 0 {
+		return params.TextDocument.URI
+	}
+	return params.URI
+}
+
+// touch marks the open document as just used.
+func (s *mateServer) touch(uri DocumentURI) {
+	if len(uri) == 0 {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if doc, ok := s.openFiles[string(uri)]; ok {
+		doc.accessed = time.Now()
+	}
+}
+
+// evictOpenFiles closes the least recently used documents while there are
+// more than maxOpenFiles open. The sessions that had them open are told, like
+// for idle documents. Must be called with the lock held.
+func (s *mateServer) evictOpenFiles() {
+	for s.maxOpenFiles > 0 && len(s.openFiles) > s.maxOpenFiles {
+		var oldest string
+		for fn, doc := range s.openFiles {
+			if len(oldest) == 0 || doc.accessed.Before(s.openFiles[oldest].accessed) {
+				oldest = fn
+			}
+		}
+		Log.WithField("uri", oldest).Debug("Too many open files, closing the least recently used")
+		doc := s.openFiles[oldest]
+		delete(s.openFiles, oldest)
+		s.client.notification("textDocument/didClose", DocumentSymbolParams{TextDocumentIdentifier{DocumentURI(oldest)}})
+		s.stream.broadcastTo(doc.sessions, "closed", KeyValue{"uri": oldest})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanOpenFiles(t *testing.T) {
+	out := &bufferCloser{}
+	now := time.Now()
+	s := mateServer{
+		client:      &lspClient{out: out},
+		idleTimeout: time.Minute,
+		openFiles: map[string]*openDocument{
+			// opened long ago, but still in use
+			"file:///used.php": {opened: now.Add(-time.Hour), accessed: now},
+			"file:///idle.php": {opened: now.Add(-time.Hour), accessed: now.Add(-2 * time.Minute)},
+		},
+	}
+
+	s.touch("file:///used.php")
+	s.cleanOpenFiles()
+	if _, ok := s.openFiles["file:///used.php"]; !ok {
+		t.Errorf("Expected the recently used document to stay open")
+	}
+	if _, ok := s.openFiles["file:///idle.php"]; ok {
+		t.Errorf("Expected the idle document to be closed")
+	}
+	if !strings.Contains(out.String(), "file:///idle.php") || strings.Contains(out.String(), "file:///used.php") {
+		t.Errorf("Expected didClose for the idle document only, but sent %s", out.String())
+	}
+
+	s.idleTimeout = 0
+	s.openFiles["file:///idle.php"] = &openDocument{accessed: now.Add(-time.Hour)}
+	s.cleanOpenFiles()
+	if _, ok := s.openFiles["file:///idle.php"]; !ok {
+		t.Errorf("Expected no document to be closed with the idle time out disabled")
+	}
+}
+
+func TestEvictOpenFiles(t *testing.T) {
+	out := &bufferCloser{}
+	s := mateServer{
+		client:       &lspClient{out: out, config: config{languages: []string{"php"}}},
+		openFiles:    make(map[string]*openDocument),
+		maxOpenFiles: 3,
+		requestID:    initializeRequestID,
+		pending:      make(map[int]*pendingRequest),
+	}
+	events := s.stream.subscribe("one")
+	open := func(uri string) {
+		opened := strings.Count(out.String(), "textDocument/didOpen")
+		go s.onDidOpen(mateRequest{Method: "didOpen", Body: json.RawMessage(`{"uri":"` + uri + `","text":"<?php"}`), Session: "one"}, make(kvChan, 1))
+		for strings.Count(out.String(), "textDocument/didOpen") == opened {
+			time.Sleep(time.Millisecond)
+		}
+		// keeps the access times apart
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	open("file:///a.php")
+	open("file:///b.php")
+	open("file:///c.php")
+	s.touch("file:///a.php")
+	open("file:///d.php")
+
+	s.Lock()
+	_, evicted := s.openFiles["file:///b.php"]
+	left := len(s.openFiles)
+	s.Unlock()
+	if evicted || left != 3 {
+		t.Errorf("Expected file:///b.php to be closed and 3 documents left, but got %d", left)
+	}
+	if closed := strings.Count(out.String(), "textDocument/didClose"); closed != 1 || !strings.Contains(out.String(), `"uri":"file:///b.php"}}`) {
+		t.Errorf("Expected didClose for file:///b.php only, but sent %s", out.String())
+	}
+	select {
+	case e := <-events:
+		if e.name != "closed" || e.data.(KeyValue)["uri"] != "file:///b.php" {
+			t.Errorf("Expected a closed event for file:///b.php, but got %+v", e)
+		}
+	default:
+		t.Errorf("Expected the session to be told about the closed document")
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+// clientOptions are editor preferences sent along with the initialize request.
+type clientOptions struct {
+	// excludeKinds lists completion item kinds dropped from completion results.
+	excludeKinds map[CompletionItemKind]bool
+	// dedupeCompletion collapses completion items with the same label and kind.
+	dedupeCompletion bool
+	// signatureHelpFallback synthesizes a signature from hover when the server
+	// can't resolve the call.
+	signatureHelpFallback bool
+	// compactThreshold is the completion list size above which items are sent
+	// with labels and kinds only, leaving the rest to completionItem/resolve.
+	// Zero disables compacting.
+	compactThreshold int
+	// kindPriority rewrites completion sortText by kind priority, then label,
+	// when set. Kinds missing from the table sort last.
+	kindPriority map[CompletionItemKind]int
+	// snippetSupport is false for editors that insert snippet placeholders
+	// literally, their completion inserts are turned into plain text.
+	snippetSupport bool
+	// structuredHover returns hover as signature, documentation and source
+	// instead of the raw contents.
+	structuredHover bool
+	// diagnosticSources keeps only diagnostics from these sources, all of
+	// them when empty.
+	diagnosticSources map[string]bool
+	// replayOnRestart reinitializes a crashed language server and sends the
+	// interrupted read only requests again.
+	replayOnRestart bool
+	// plainDocumentation is set when the editor prefers plaintext completion
+	// documentation, markdown the server sends anyway is stripped.
+	plainDocumentation bool
+	// definitionKind adds the kind of the defined symbol to definition
+	// results.
+	definitionKind bool
+}
+
+// defaultKindPriority orders completion items when sortText normalization is
+// enabled without a custom table.
+var defaultKindPriority = []int{
+	int(CIKVariable),
+	int(CIKField),
+	int(CIKProperty),
+	int(CIKMethod),
+	int(CIKFunction),
+	int(CIKConstructor),
+	int(CIKConstant),
+	int(CIKEnumMember),
+	int(CIKClass),
+	int(CIKInterface),
+	int(CIKEnum),
+	int(CIKModule),
+	int(CIKKeyword),
+	int(CIKSnippet),
+	int(CIKText),
+}
+
+func parseClientOptions(params KeyValue) clientOptions {
+	opts := clientOptions{
+		excludeKinds:          make(map[CompletionItemKind]bool),
+		dedupeCompletion:      params.bool("dedupeCompletion", false),
+		signatureHelpFallback: params.bool("signatureHelpFallback", false),
+		compactThreshold:      params.int("completionCompactThreshold", 0),
+		snippetSupport:        params.bool("snippetSupport", true),
+		structuredHover:       params.bool("structuredHover", false),
+		replayOnRestart:       params.bool("replayOnRestart", false),
+		definitionKind:        params.bool("definitionKind", false),
+	}
+	if params.bool("normalizeSortText", false) {
+		opts.kindPriority = make(map[CompletionItemKind]int)
+		for i, kind := range params.ints("completionKindPriority", defaultKindPriority) {
+			opts.kindPriority[CompletionItemKind(kind)] = i
+		}
+	}
+	if formats := documentationFormat(params); len(formats) > 0 && formats[0] == string(MKPlainText) {
+		opts.plainDocumentation = true
+	}
+	if sources := params.strings("diagnosticSources", nil); len(sources) > 0 {
+		opts.diagnosticSources = sourceSet(sources)
+	}
+	for _, kind := range params.ints("excludeCompletionKinds", nil) {
+		opts.excludeKinds[CompletionItemKind(kind)] = true
+	}
+	return opts
+}
+
+// rewritesCompletion reports whether the options change completion results,
+// which are passed through as the server sent them otherwise.
+func (opts clientOptions) rewritesCompletion() bool {
+	return len(opts.excludeKinds) > 0 || opts.dedupeCompletion || opts.kindPriority != nil ||
+		!opts.snippetSupport || opts.plainDocumentation || opts.compactThreshold > 0
+}
+
+// clientOptions returns the preferences of the editor session, the defaults
+// if it didn't initialize.
+func (s *mateServer) clientOptions(session string) clientOptions {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	if opts, ok := s.options[session]; ok {
+		return opts
+	}
+	return parseClientOptions(KeyValue{})
+}
+
+func (s *mateServer) setClientOptions(session string, opts clientOptions) {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	if s.options == nil {
+		s.options = make(map[string]clientOptions)
+	}
+	s.options[session] = opts
+}
+
+// replayOnRestart reports whether any editor session wants the language
+// server reinitialized after a crash.
+func (s *mateServer) replayOnRestart() bool {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	for _, opts := range s.options {
+		if opts.replayOnRestart {
+			return true
+		}
+	}
+	return false
+}
+
+// documentationFormat returns the completion documentation formats the editor
+// accepts, most preferred first.
+func documentationFormat(params KeyValue) []string {
+	return params.strings("documentationFormat", []string{string(MKMarkdown), string(MKPlainText)})
+}
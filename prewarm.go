@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// defaultPrewarmTimeout keeps prewarming below the HTTP time out.
+const defaultPrewarmTimeout = 15 * time.Second
+
+type prewarmParams struct {
+	URIs []DocumentURI `json:"uris"`
+	// Timeout in milliseconds
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// onPrewarm opens and closes the given files so the language server caches
+// their symbols before the editor needs them. It replies with what was warmed
+// when the deadline hits, a file still being warmed then is closed later.
+func (s *mateServer) onPrewarm(mr mateRequest, cb kvChan) {
+	params := prewarmParams{}
+	if err := json.Unmarshal(mr.Body, &params); err != nil {
+		cb <- &KeyValue{"result": "error", "message": err.Error()}
+		return
+	}
+	timeout := defaultPrewarmTimeout
+	if params.Timeout > 0 {
+		timeout = time.Duration(params.Timeout) * time.Millisecond
+	}
+	deadline := time.After(mr.replyWithin(timeout))
+
+	warmed := 0
+	for i, uri := range params.URIs {
+		done := make(chan bool, 1)
+		go func(uri DocumentURI) { done <- s.prewarm(uri) }(uri)
+		select {
+		case ok := <-done:
+			if ok {
+				warmed++
+			}
+		case <-deadline:
+			Log.WithField("left", len(params.URIs)-i).Warn("prewarm timed out")
+			cb <- &KeyValue{"result": KeyValue{"total": len(params.URIs), "warmed": warmed, "timedOut": true}}
+			return
+		}
+	}
+	cb <- &KeyValue{"result": KeyValue{"total": len(params.URIs), "warmed": warmed, "timedOut": false}}
+}
+
+// prewarm loads the file from disk and lets the server analyze it. Documents
+// the editor already opened are left alone, and documents the editor opens
+// while they're prewarmed are handed over to it.
+func (s *mateServer) prewarm(uri DocumentURI) bool {
+	s.Lock()
+	_, open := s.openFiles[string(uri)]
+	s.Unlock()
+	if open {
+		return true
+	}
+
+	languageID, err := s.client.languageID(TextDocumentItem{URI: uri})
+	if err != nil {
+		Log.Warn(err)
+		return false
+	}
+	text, err := ioutil.ReadFile(uriToPath(uri))
+	if err != nil {
+		Log.Warn(err)
+		return false
+	}
+	s.Lock()
+	if _, open := s.openFiles[string(uri)]; open || s.prewarming[string(uri)] {
+		s.Unlock()
+		return true
+	}
+	if s.prewarming == nil {
+		s.prewarming = make(map[string]bool)
+	}
+	s.prewarming[string(uri)] = true
+	s.client.notification("textDocument/didOpen", DidOpenTextDocumentParams{TextDocumentItem{
+		URI:        uri,
+		LanguageID: languageID,
+		Version:    1,
+		Text:       string(text),
+	}})
+	s.Unlock()
+	// the server answers once the document has been parsed and indexed
+	_, err = s.backgroundRequest("textDocument/documentSymbol", DocumentSymbolParams{TextDocumentIdentifier{uri}}).wait()
+	s.Lock()
+	defer s.Unlock()
+	if s.prewarming[string(uri)] {
+		delete(s.prewarming, string(uri))
+		s.client.notification("textDocument/didClose", DidCloseTextDocumentParams{TextDocumentIdentifier{uri}})
+	}
+	return err == nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrewarmHandsOverToEditor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prewarm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.php")
+	if err := ioutil.WriteFile(path, []byte("<?php"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	uri := DocumentURI("file://" + path)
+
+	out := &bufferCloser{}
+	s := mateServer{
+		client:     &lspClient{out: out, config: config{languages: []string{"php"}}},
+		openFiles:  make(map[string]*openDocument),
+		prewarming: make(map[string]bool),
+		requestID:  initializeRequestID,
+		pending:    make(map[int]*pendingRequest),
+	}
+	warmed := make(chan bool, 1)
+	go func() { warmed <- s.prewarm(uri) }()
+	// the editor opens the document while the server parses it
+	symbols := pendingID(t, &s, "textDocument/documentSymbol")
+	go s.onDidOpen(mateRequest{Method: "didOpen", Body: json.RawMessage(`{"uri":"` + string(uri) + `","text":"<?php"}`)}, make(kvChan, 1))
+	for strings.Count(out.String(), "textDocument/didOpen") < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	s.resolve(&response{ID: symbols, Result: json.RawMessage(`[]`)})
+
+	if !<-warmed {
+		t.Errorf("Expected %s to be prewarmed", uri)
+	}
+	// open by prewarm, closed to hand it over, open by the editor
+	sent := regexp.MustCompile(`textDocument/did(Open|Close)`).FindAllString(out.String(), -1)
+	want := []string{"textDocument/didOpen", "textDocument/didClose", "textDocument/didOpen"}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("Expected %v, but got %v", want, sent)
+	}
+}
+
+func TestOnPrewarmTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prewarm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.php")
+	if err := ioutil.WriteFile(path, []byte("<?php"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := mateServer{
+		client:    &lspClient{out: &bufferCloser{}, config: config{languages: []string{"php"}}},
+		openFiles: make(map[string]*openDocument),
+		requestID: initializeRequestID,
+		pending:   make(map[int]*pendingRequest),
+	}
+	timeout := 200 * time.Millisecond
+	cb := make(kvChan, 1)
+	// the server never answers
+	go s.onPrewarm(mateRequest{
+		Method:  "prewarm",
+		Body:    json.RawMessage(`{"uris":["file://` + path + `"],"timeout":30000}`),
+		Timeout: timeout,
+	}, cb)
+
+	select {
+	case reply := <-cb:
+		result, ok := (*reply)["result"].(KeyValue)
+		if !ok || result["timedOut"] != true || result["warmed"] != 0 {
+			t.Errorf("Expected prewarm to time out, but got %v", *reply)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("Expected a reply before the request times out")
+	}
+}
+
+// pendingID waits for a request for method and returns its id.
+func pendingID(t *testing.T, s *mateServer, method string) int {
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		s.pendingLock.Lock()
+		for id, p := range s.pending {
+			if p.method == method {
+				s.pendingLock.Unlock()
+				return id
+			}
+		}
+		s.pendingLock.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("No %s request was sent", method)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// workDoneRequest is a running request that reports progress.
+type workDoneRequest struct {
+	method string
+	// session of the editor that sent the request
+	session string
+}
+
+// trackWorkDone sets a work done token on the params, unless the editor
+// passed its own, and routes the progress reported for it to the event
+// stream of the session. The token must be released with untrackWorkDone.
+func (s *mateServer) trackWorkDone(params *WorkDoneProgressParams, method, session string) string {
+	if params.WorkDoneToken == nil {
+		params.WorkDoneToken = s.newToken("mate")
+	}
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+	token := fmt.Sprint(params.WorkDoneToken)
+	s.workDone[token] = workDoneRequest{method: method, session: session}
+	return token
+}
+
+// newToken returns a token unique to the bridge, to tie results delivered
+// later on the event stream to their request.
+func (s *mateServer) newToken(prefix string) string {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+	s.tokenID++
+	return prefix + "-" + strconv.Itoa(s.tokenID)
+}
+
+func (s *mateServer) untrackWorkDone(token string) {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+	delete(s.workDone, token)
+}
+
+// forwardProgress sends $/progress notifications for tracked tokens to the
+// event stream of the session that sent the request.
+func (s *mateServer) forwardProgress(params KeyValue) {
+	jsParams, _ := json.Marshal(params)
+	p := progressParams{}
+	if err := json.Unmarshal(jsParams, &p); err != nil {
+		return
+	}
+	token := fmt.Sprint(p.Token)
+	s.pendingLock.Lock()
+	r, ok := s.workDone[token]
+	s.pendingLock.Unlock()
+	if !ok {
+		return
+	}
+	s.stream.broadcastTo(map[string]bool{r.session: true}, "progress", KeyValue{
+		"token":      token,
+		"method":     r.method,
+		"kind":       p.Value.Kind,
+		"title":      p.Value.Title,
+		"message":    p.Value.Message,
+		"percentage": p.Value.Percentage,
+	})
+}
+
+// requestWithProgress is requestAndWait for requests that report progress.
+func (s *mateServer) requestWithProgress(method, session string, params interface{}, workDone *WorkDoneProgressParams, cb kvChan) {
+	token := s.trackWorkDone(workDone, method, session)
+	defer s.untrackWorkDone(token)
+	s.requestAndWait(method, params, cb)
+}
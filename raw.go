@@ -0,0 +1,56 @@
+package main
+
+import "encoding/json"
+
+// wantsRawResult reports whether the request body asks for the unprocessed
+// server results with "rawResult": true.
+func wantsRawResult(body json.RawMessage) bool {
+	flag := struct {
+		RawResult bool `json:"rawResult"`
+	}{}
+	json.Unmarshal(body, &flag)
+	return flag.RawResult
+}
+
+// withRawResult returns the channel to pass to the handler instead of cb. The
+// server results it records are added to its reply as "_raw" before the reply
+// is passed on to cb.
+func (s *mateServer) withRawResult(cb kvChan) kvChan {
+	handlerCb := make(kvChan, 1)
+	s.cacheLock.Lock()
+	s.rawResults[handlerCb] = []json.RawMessage{}
+	s.cacheLock.Unlock()
+
+	go func() {
+		reply := <-handlerCb
+		s.cacheLock.Lock()
+		raw := s.rawResults[handlerCb]
+		delete(s.rawResults, handlerCb)
+		s.cacheLock.Unlock()
+		if reply != nil {
+			switch len(raw) {
+			case 0:
+				(*reply)["_raw"] = nil
+			case 1:
+				(*reply)["_raw"] = raw[0]
+			default:
+				(*reply)["_raw"] = raw
+			}
+		}
+		cb <- reply
+	}()
+	return handlerCb
+}
+
+// recordRaw keeps the server result for the reply sent to cb, if the request
+// asked for it.
+func (s *mateServer) recordRaw(cb kvChan, result json.RawMessage) {
+	if result == nil {
+		return
+	}
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+	if raw, ok := s.rawResults[cb]; ok {
+		s.rawResults[cb] = append(raw, result)
+	}
+}
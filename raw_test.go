@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestWithRawResult(t *testing.T) {
+	s := mateServer{rawResults: make(map[kvChan][]json.RawMessage)}
+	cb := make(kvChan, 1)
+	handlerCb := s.withRawResult(cb)
+
+	s.recordRaw(handlerCb, json.RawMessage(`{"contents":"foo"}`))
+	s.recordRaw(cb, json.RawMessage(`{"ignored":true}`))
+	handlerCb <- &KeyValue{"result": "foo"}
+
+	reply := <-cb
+	want := KeyValue{"result": "foo", "_raw": json.RawMessage(`{"contents":"foo"}`)}
+	if !reflect.DeepEqual(*reply, want) {
+		t.Errorf("Expected %+v, but got %+v", want, *reply)
+	}
+	if len(s.rawResults) != 0 {
+		t.Errorf("Raw results weren't released: %+v", s.rawResults)
+	}
+	if !wantsRawResult(json.RawMessage(`{"rawResult":true,"uri":"file:///a.php"}`)) || wantsRawResult(json.RawMessage(`["file:///a.php"]`)) {
+		t.Errorf("rawResult flag isn't read from the body")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import "time"
+
+// replayTimeout is how long a request interrupted by a crash waits for the
+// restarted language server, below the HTTP time out.
+const replayTimeout = 10 * time.Second
+
+// replayable lists the requests without side effects, safe to send again to
+// a restarted language server.
+var replayable = map[string]bool{
+	"textDocument/hover":         true,
+	"textDocument/definition":    true,
+	"textDocument/completion":    true,
+	"textDocument/signatureHelp": true,
+}
+
+// abandonPending resolves the requests the crashed language server will
+// never answer.
+func (s *mateServer) abandonPending() {
+	s.pendingLock.Lock()
+	pending := s.pending
+	s.pending = make(map[int]*pendingRequest)
+	s.completions = make(map[completionKey]int)
+	s.timeouts = 0
+	s.pendingLock.Unlock()
+	for id, p := range pending {
+		p.timer.Stop()
+		p.done <- &response{ID: id, Error: &LSPError{Code: ServerRestarted, Message: "language server restarted"}}
+	}
+}
+
+// reinitialize sends the last successful initialize to the restarted
+// language server and opens the documents that were open in it before.
+// restored is closed when it's done, successful or not.
+func (s *mateServer) reinitialize(documents map[string]*openDocument, restored chan struct{}) {
+	defer close(restored)
+	s.Lock()
+	mr := s.lastInitialize
+	s.Unlock()
+	if mr == nil {
+		return
+	}
+	cb := make(kvChan, 1)
+	s.onInitialize(*mr, cb)
+	result := (*<-cb)["result"]
+	Log.WithField("result", result).Info("Reinitialized language server after restart")
+	if result == "ok" {
+		s.reopenDocuments(documents)
+	}
+}
+
+// reopenDocuments opens the documents again in the restarted language server.
+// Documents the editor opened in the meantime are left alone.
+func (s *mateServer) reopenDocuments(documents map[string]*openDocument) {
+	s.Lock()
+	defer s.Unlock()
+	for fn, doc := range documents {
+		if _, ok := s.openFiles[fn]; ok {
+			continue
+		}
+		s.openFiles[fn] = doc
+		s.client.notification("textDocument/didOpen", DidOpenTextDocumentParams{doc.item})
+	}
+	s.evictOpenFiles()
+	Log.WithField("documents", len(documents)).Info("Reopened documents after restart")
+}
+
+// waitRestored blocks until the restarted language server is initialized and
+// has the open documents back, or the timeout passes.
+func (s *mateServer) waitRestored(timeout time.Duration) bool {
+	s.Lock()
+	restored := s.restored
+	s.Unlock()
+	select {
+	case <-restored:
+	case <-time.After(timeout):
+		return false
+	}
+	return s.status.isInitialized()
+}
+
+// replay waits for the language server to be back after a restart and
+// reports whether the interrupted request may be sent again.
+func (s *mateServer) replay(method string, opts clientOptions) bool {
+	if !replayable[method] || !opts.replayOnRestart {
+		return false
+	}
+	if !s.waitRestored(replayTimeout) {
+		Log.WithField("method", method).Warn("language server is not back, not replaying")
+		return false
+	}
+	Log.WithField("method", method).Info("replaying request after restart")
+	return true
+}
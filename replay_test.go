@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bufferCloser collects the messages the client sends to the language server.
+type bufferCloser struct {
+	buf bytes.Buffer
+	sync.Mutex
+}
+
+func (b *bufferCloser) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *bufferCloser) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func (b *bufferCloser) Close() error { return nil }
+
+func TestReopenDocuments(t *testing.T) {
+	out := &bufferCloser{}
+	reopened := &openDocument{item: TextDocumentItem{URI: "file:///a.php", Text: "<?php"}}
+	opened := &openDocument{item: TextDocumentItem{URI: "file:///b.php", Text: "<?php // new"}}
+	s := mateServer{
+		client:    &lspClient{out: out},
+		openFiles: map[string]*openDocument{"file:///b.php": opened},
+	}
+
+	s.reopenDocuments(map[string]*openDocument{
+		"file:///a.php": reopened,
+		"file:///b.php": {item: TextDocumentItem{URI: "file:///b.php", Text: "<?php // old"}},
+	})
+	if s.openFiles["file:///a.php"] != reopened {
+		t.Errorf("Expected file:///a.php to be open again")
+	}
+	if s.openFiles["file:///b.php"] != opened {
+		t.Errorf("Expected file:///b.php opened after the restart to be kept")
+	}
+	if sent := strings.Count(out.String(), "textDocument/didOpen"); sent != 1 {
+		t.Errorf("Expected 1 didOpen, but got %d: %s", sent, out.String())
+	}
+}
+
+func TestWaitRestored(t *testing.T) {
+	s := mateServer{restored: make(chan struct{})}
+	if s.waitRestored(10 * time.Millisecond) {
+		t.Errorf("Expected not restored before the documents are reopened")
+	}
+	close(s.restored)
+	if s.waitRestored(10 * time.Millisecond) {
+		t.Errorf("Expected not restored while the server isn't initialized")
+	}
+	s.status.setInitialized(true)
+	if !s.waitRestored(10 * time.Millisecond) {
+		t.Errorf("Expected restored")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
 	"os"
 	"runtime/debug"
@@ -13,18 +14,118 @@ import (
 	"github.com/tectiv3/go-lsp-client/events"
 )
 
-const cacheTime = 5 * time.Second
+// Request id allocation: initializeRequestID is reserved for the initialize
+// request, whose response startListeners recognizes by that id. Every other
+// request gets its id from nextRequestID, which never hands out the reserved
+// id, even if the counter is reset, and wraps around before overflowing.
+const (
+	initializeRequestID = 1
+	firstRequestID      = initializeRequestID + 1
+	maxRequestID        = math.MaxInt32
+)
+
+// defaultRequestTimeout is how long a request to the language server stays
+// pending before its waiter is resolved with a timeout error.
+const defaultRequestTimeout = 2 * time.Second
+
+// requestTimeouts overrides defaultRequestTimeout for slower methods.
+var requestTimeouts = map[string]time.Duration{
+	"textDocument/completion": 5 * time.Second,
+	"workspace/symbol":        10 * time.Second,
+}
+
+// defaultHTTPTimeout is how long the editor waits for a result, unless it
+// asks for another time out in milliseconds with the X-Timeout header, capped
+// at maxHTTPTimeout.
+const (
+	defaultHTTPTimeout = 20 * time.Second
+	maxHTTPTimeout     = 2 * time.Minute
+)
+
+// maxReplyMargin is the most time handlers that reply with partial results
+// keep between their own deadline and the HTTP time out.
+const maxReplyMargin = 500 * time.Millisecond
 
 type mateRequest struct {
 	Method string
 	Body   json.RawMessage
+	// Session of the editor window, from the X-Session header
+	Session string `json:"-"`
+	// Timeout of the HTTP request
+	Timeout time.Duration `json:"-"`
+}
+
+// pendingRequest is a request sent to the language server that has not been
+// answered yet. done receives either the response or a timeout error.
+type pendingRequest struct {
+	id     int
+	method string
+	timer  *time.Timer
+	done   chan *response
+	// watched requests count towards the hang threshold when they time out
+	watched bool
+}
+
+// openDocument is a document the editor opened in the language server.
+type openDocument struct {
+	item   TextDocumentItem
+	opened time.Time
+	// accessed is the time of the last request for the document
+	accessed time.Time
+	// sessions that have the document open
+	sessions map[string]bool
 }
 
 type mateServer struct {
-	client      *lspClient
-	openFiles   map[string]time.Time
-	requestID   int
-	initialized bool
+	client    *lspClient
+	openFiles map[string]*openDocument
+	// prewarming are the documents prewarm opened and will close, unless the
+	// editor opens them meanwhile
+	prewarming map[string]bool
+	// maxOpenFiles caps openFiles, 0 for no limit
+	maxOpenFiles int
+	// idleTimeout is how long an unused document stays open, 0 for ever
+	idleTimeout time.Duration
+	// batchConcurrency limits the requests of a batch in flight
+	batchConcurrency int
+	requestID        int
+	pending          map[int]*pendingRequest
+	completions      map[completionKey]int
+	// workDone maps the work done tokens of running requests to the request
+	workDone map[string]workDoneRequest
+	tokenID  int
+	// timeouts counts consecutive request time outs, a hung server is
+	// restarted once they reach hangThreshold
+	timeouts      int
+	hangThreshold int
+	pendingLock   sync.Mutex
+	initialized   bool
+	// lastInitialize is resent to a restarted language server
+	lastInitialize *mateRequest
+	// restored is closed once a restarted language server got the open
+	// documents back
+	restored chan struct{}
+	// storageDirs maps configured storage paths to the usable directories
+	storageDirs map[string]string
+	config      KeyValue
+	// options of the editor sessions
+	options map[string]clientOptions
+	// capabilities of the language server, known once it's initialized
+	capabilities ServerCapabilities
+	configLock   sync.Mutex
+	status       serverStatus
+	diagnostics  diagnosticsStore
+	stream       eventStream
+	traces       traceStore
+	// rawResults collects the server results of requests that asked for them
+	rawResults map[kvChan][]json.RawMessage
+	// completionCache keeps the full items of the last compacted completion
+	// list of each session
+	completionCache map[string]cachedCompletion
+	// appliedEdits collects the edits of the running command
+	appliedEdits []WorkspaceEdit
+	cacheLock    sync.Mutex
+	commandLock  sync.Mutex
 	sync.Mutex
 }
 
@@ -38,6 +139,16 @@ func (s *mateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	Log.WithField("method", r.Method).WithField("length", r.ContentLength).Debug(r.URL.Path)
 
+	if r.Method == http.MethodGet && r.URL.Path == "/events" {
+		s.stream.serveEvents(w, r, sessionID(r))
+		return
+	}
+
+	if r.Method == http.MethodGet && r.URL.Path == "/ready" {
+		s.serveReady(w)
+		return
+	}
+
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -51,10 +162,13 @@ func (s *mateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	mr.Session = sessionID(r)
+	mr.Timeout = httpTimeout(r)
 
-	resultChan := make(kvChan)
+	// buffered so a late result doesn't block the handler after a time out
+	resultChan := make(kvChan, 1)
 	var result *KeyValue
-	tick := time.After(20 * time.Second)
+	tick := time.After(mr.Timeout)
 
 	go s.processRequest(mr, resultChan)
 
@@ -80,20 +194,214 @@ func (s *mateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(result)
 }
 
-func (s *mateServer) request(method string, params interface{}) int {
-	s.Lock()
-	s.requestID++
-	reqID := s.requestID
-	s.Unlock()
+// httpTimeout returns the time out requested in the X-Timeout header.
+func httpTimeout(r *http.Request) time.Duration {
+	header := r.Header.Get("X-Timeout")
+	if len(header) == 0 {
+		return defaultHTTPTimeout
+	}
+	ms, err := strconv.Atoi(header)
+	if err != nil || ms <= 0 {
+		Log.WithField("X-Timeout", header).Warn("Invalid time out, using the default")
+		return defaultHTTPTimeout
+	}
+	if timeout := time.Duration(ms) * time.Millisecond; timeout < maxHTTPTimeout {
+		return timeout
+	}
+	return maxHTTPTimeout
+}
+
+// replyWithin returns wait, shortened if needed so the handler still has
+// time to reply before the HTTP time out of the request. A wait of 0 takes
+// all the time there is.
+func (mr mateRequest) replyWithin(wait time.Duration) time.Duration {
+	timeout := mr.Timeout
+	if timeout <= 0 {
+		timeout = defaultHTTPTimeout
+	}
+	margin := timeout / 10
+	if margin > maxReplyMargin {
+		margin = maxReplyMargin
+	}
+	if available := timeout - margin; wait <= 0 || wait > available {
+		return available
+	}
+	return wait
+}
+
+func (s *mateServer) request(method string, params interface{}) *pendingRequest {
+	return s.sendRequest(method, params, true)
+}
+
+// backgroundRequest sends a request the bridge makes on its own rather than
+// for the editor. Its time outs aren't taken as a sign of a hung server.
+func (s *mateServer) backgroundRequest(method string, params interface{}) *pendingRequest {
+	return s.sendRequest(method, params, false)
+}
+
+func (s *mateServer) sendRequest(method string, params interface{}, watched bool) *pendingRequest {
+	timeout, ok := requestTimeouts[method]
+	if !ok {
+		timeout = defaultRequestTimeout
+	}
+
+	s.pendingLock.Lock()
+	p := &pendingRequest{id: s.nextRequestID(), method: method, done: make(chan *response, 1), watched: watched}
+	p.timer = time.AfterFunc(timeout, func() { s.expire(p.id) })
+	s.pending[p.id] = p
+	s.pendingLock.Unlock()
+
+	s.client.request(p.id, method, params)
+	return p
+}
+
+// nextRequestID allocates an id for a new request. Ids of requests still
+// pending after a wrap around are skipped. Must be called with pendingLock held.
+func (s *mateServer) nextRequestID() int {
+	for {
+		if s.requestID < firstRequestID || s.requestID >= maxRequestID {
+			s.requestID = initializeRequestID
+		}
+		s.requestID++
+		if _, ok := s.pending[s.requestID]; !ok {
+			return s.requestID
+		}
+	}
+}
+
+// resolve hands the response to the waiter of the request it answers.
+// Returns false if no request with this id is pending.
+func (s *mateServer) resolve(r *response) bool {
+	s.pendingLock.Lock()
+	p, ok := s.pending[r.ID]
+	delete(s.pending, r.ID)
+	if ok {
+		s.timeouts = 0
+	}
+	s.pendingLock.Unlock()
+	if !ok {
+		return false
+	}
+	p.timer.Stop()
+	p.done <- r
+	return true
+}
+
+// expire drops the pending request and resolves its waiter with a timeout
+// error, whether or not anybody is still waiting for it. Only time outs of
+// editor requests outside of indexing, when the server is expected to be
+// slow, count towards the hang threshold.
+func (s *mateServer) expire(id int) {
+	indexing := s.status.isIndexing()
+	s.pendingLock.Lock()
+	p, ok := s.pending[id]
+	delete(s.pending, id)
+	hung := false
+	if ok && p.watched && !indexing {
+		s.timeouts++
+		hung = s.hangThreshold > 0 && s.timeouts >= s.hangThreshold
+		if hung {
+			s.timeouts = 0
+		}
+	}
+	s.pendingLock.Unlock()
+	if !ok {
+		return
+	}
+	Log.WithField("id", id).Warn(p.method + " timed out")
+	if hung {
+		Log.WithField("timeouts", s.hangThreshold).Error("Language server is not responding, killing it to restart")
+		s.client.kill()
+	}
+	p.done <- &response{ID: id, Error: &LSPError{Code: RequestTimedOut, Message: p.method + " timed out"}}
+}
+
+// cancel asks the server to stop working on the request and resolves its
+// waiter with a cancellation error.
+func (s *mateServer) cancel(id int) {
+	s.pendingLock.Lock()
+	p, ok := s.pending[id]
+	delete(s.pending, id)
+	s.pendingLock.Unlock()
+	if !ok {
+		return
+	}
+	p.timer.Stop()
+	Log.WithField("id", id).Debug(p.method + " canceled")
+	s.client.notification("$/cancelRequest", CancelParams{ID: id})
+	p.done <- &response{ID: id, Error: &LSPError{Code: RequestCancelled, Message: p.method + " canceled"}}
+}
+
+// cancelAll cancels every pending request and returns how many there were.
+func (s *mateServer) cancelAll() int {
+	s.pendingLock.Lock()
+	ids := make([]int, 0, len(s.pending))
+	for id := range s.pending {
+		ids = append(ids, id)
+	}
+	s.pendingLock.Unlock()
+	for _, id := range ids {
+		s.cancel(id)
+	}
+	return len(ids)
+}
+
+// wait blocks until the request is answered or expired.
+func (p *pendingRequest) wait() (json.RawMessage, error) {
+	r := <-p.done
+	if r.Error != nil {
+		return nil, r.Error
+	}
+	return r.Result, nil
+}
+
+// call sends a request to the language server and blocks until it's answered.
+func (s *mateServer) call(method string, params interface{}) (json.RawMessage, error) {
+	return s.callFor(clientOptions{}, method, params)
+}
+
+// callFor is call on behalf of an editor session, whose options decide
+// whether the request is replayed after a restart.
+func (s *mateServer) callFor(opts clientOptions, method string, params interface{}) (json.RawMessage, error) {
+	return s.retry(method, opts, func() (json.RawMessage, error) {
+		return s.request(method, params).wait()
+	})
+}
 
-	s.client.request(reqID, method, params)
-	return reqID
+// retry sends a request with send and sends it again when that's what its
+// error calls for. A request rejected because the document changed while it
+// was in flight is retried once against the new content, one interrupted by a
+// crash may be replayed against the restarted server.
+func (s *mateServer) retry(method string, opts clientOptions, send func() (json.RawMessage, error)) (json.RawMessage, error) {
+	result, err := send()
+	if isErrorCode(err, ContentModified) {
+		Log.WithField("method", method).Debug("content modified, retrying")
+		result, err = send()
+	}
+	if isErrorCode(err, ServerRestarted) && s.replay(method, opts) {
+		result, err = send()
+	}
+	return result, err
 }
 
 func (s *mateServer) requestAndWait(method string, params interface{}, cb kvChan) {
-	reqID := s.request(method, params)
-	// block until got response or timeout
-	s.wait("request."+strconv.Itoa(reqID), cb)
+	result, err := s.call(method, params)
+	s.recordRaw(cb, result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	cb <- &KeyValue{"result": result}
+}
+
+// replyError sends the error to the editor, along with its code if it came
+// from the language server.
+func replyError(cb kvChan, err error) {
+	reply := KeyValue{"result": "error", "message": err.Error()}
+	if lspErr, ok := err.(*LSPError); ok {
+		reply["code"] = lspErr.Code
+	}
+	cb <- &reply
 }
 
 func (s *mateServer) wait(event string, cb kvChan) {
@@ -121,6 +429,16 @@ func (s *mateServer) wait(event string, cb kvChan) {
 func (s *mateServer) processRequest(mr mateRequest, cb kvChan) {
 	defer s.handlePanic(mr)
 	Log.WithField("method", mr.Method).Trace(string(mr.Body))
+	if err := validateRequest(mr); err != nil {
+		Log.WithField("method", mr.Method).Warn(err)
+		replyError(cb, &LSPError{Code: InvalidParams, Message: err.Error()})
+		return
+	}
+	s.touch(requestURI(mr.Body))
+	if wantsRawResult(mr.Body) {
+		cb = s.withRawResult(cb)
+	}
+	opts := s.clientOptions(mr.Session)
 	switch mr.Method {
 	case "hover":
 		params := TextDocumentPositionParams{}
@@ -128,27 +446,139 @@ func (s *mateServer) processRequest(mr mateRequest, cb kvChan) {
 			cb <- &KeyValue{"result": "error", "message": err.Error()}
 			return
 		}
-		s.requestAndWait("textDocument/hover", params, cb)
+		// "partial" opts in to a preliminary hover when the server is slow
+		options := struct {
+			Partial bool   `json:"partial"`
+			Token   string `json:"token"`
+		}{}
+		json.Unmarshal(mr.Body, &options)
+		if options.Partial {
+			s.onPartialHover(mr, params, options.Token, cb)
+			return
+		}
+		s.onHover(params, opts, cb)
 	case "completion":
 		params := CompletionParams{}
 		if err := json.Unmarshal(mr.Body, &params); err != nil {
 			cb <- &KeyValue{"result": "error", "message": err.Error()}
 			return
 		}
-		s.requestAndWait("textDocument/completion", params, cb)
+		s.onCompletion(params, mr.Session, opts, cb)
+	case "resolveCompletion":
+		item := CompletionItem{}
+		if err := json.Unmarshal(mr.Body, &item); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+		s.onResolveCompletion(item, mr.Session, opts, cb)
+	case "resolveCompletionBatch":
+		s.onResolveCompletionBatch(mr, cb)
+	case "signatureHelp":
+		params := TextDocumentPositionParams{}
+		if err := json.Unmarshal(mr.Body, &params); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+		s.onSignatureHelp(params, opts, cb)
+	case "canTriggerSignatureHelp":
+		params := TextDocumentPositionParams{}
+		if err := json.Unmarshal(mr.Body, &params); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+		s.onCanTriggerSignatureHelp(params, cb)
 	case "definition":
 		params := TextDocumentPositionParams{}
 		if err := json.Unmarshal(mr.Body, &params); err != nil {
 			cb <- &KeyValue{"result": "error", "message": err.Error()}
 			return
 		}
-		s.requestAndWait("textDocument/definition", params, cb)
+		s.onDefinition(params, opts, cb)
+	case "workspaceSymbol":
+		params := WorkspaceSymbolParams{}
+		if err := json.Unmarshal(mr.Body, &params); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+		s.requestWithProgress("workspace/symbol", mr.Session, &params, &params.WorkDoneProgressParams, cb)
+	case "references":
+		params := ReferenceParams{}
+		if err := json.Unmarshal(mr.Body, &params); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+		s.requestWithProgress("textDocument/references", mr.Session, &params, &params.WorkDoneProgressParams, cb)
+	case "rename":
+		params := RenameParams{}
+		if err := json.Unmarshal(mr.Body, &params); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+		s.onRename(params, cb)
+	case "codeAction":
+		params := CodeActionParams{}
+		if err := json.Unmarshal(mr.Body, &params); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+		s.onCodeAction(params, cb)
+	case "executeCommand":
+		params := ExecuteCommandParams{}
+		if err := json.Unmarshal(mr.Body, &params); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+		s.onExecuteCommand(params, cb)
+	case "organizeImports":
+		textDocument := TextDocumentIdentifier{}
+		if err := json.Unmarshal(mr.Body, &textDocument); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+		s.onOrganizeImports(textDocument, cb)
 	case "initialize":
 		s.onInitialize(mr, cb)
 	case "didOpen":
 		s.onDidOpen(mr, cb)
 	case "didClose":
 		s.onDidClose(mr, cb)
+	case "didOpenBatch":
+		s.onBatch(mr, "didOpen", cb)
+	case "hoverBatch":
+		s.onBatch(mr, "hover", cb)
+	case "didCloseBatch":
+		s.onDidCloseBatch(mr, cb)
+	case "getDiagnostics":
+		s.onGetDiagnostics(mr, cb)
+	case "waitDiagnostics":
+		s.onWaitDiagnostics(mr, cb)
+	case "prewarm":
+		s.onPrewarm(mr, cb)
+	case "codeActionKinds":
+		options, supported := s.serverCapabilities().CodeActionOptions()
+		kinds := options.CodeActionKinds
+		if kinds == nil {
+			kinds = []CodeActionKind{}
+		}
+		cb <- &KeyValue{"result": KeyValue{"supported": supported, "kinds": kinds}}
+	case "ready":
+		cb <- &KeyValue{"result": s.readiness()}
+	case "indexStats":
+		s.onIndexStats(cb)
+	case "setTrace":
+		s.onSetTrace(mr, cb)
+	case "getTrace":
+		s.onGetTrace(mr, cb)
+	case "flushCache":
+		s.onFlushCache(mr, cb)
+	case "cancelAll":
+		cb <- &KeyValue{"result": KeyValue{"canceled": s.cancelAll()}}
+	case "validate":
+		s.onValidate(mr, cb)
+	case "didChangeConfiguration":
+		s.onDidChangeConfiguration(mr, cb)
+	case "getConfiguration":
+		s.onGetConfiguration(mr, cb)
 	default:
 		cb <- &KeyValue{"result": "error", "message": "unknown method"}
 	}
@@ -156,33 +586,76 @@ func (s *mateServer) processRequest(mr mateRequest, cb kvChan) {
 }
 
 func (s *mateServer) onDidOpen(mr mateRequest, cb kvChan) {
-	s.Lock()
-	defer s.Unlock()
 	textDocument := TextDocumentItem{}
 	if err := json.Unmarshal(mr.Body, &textDocument); err != nil {
 		cb <- &KeyValue{"result": "error", "message": err.Error()}
 		return
 	}
 
+	// transient opens (peek) reuse an open document as is
+	options := struct {
+		Transient bool `json:"transient"`
+	}{}
+	if err := json.Unmarshal(mr.Body, &options); err != nil {
+		cb <- &KeyValue{"result": "error", "message": err.Error()}
+		return
+	}
+
 	fn := string(textDocument.URI)
 	if len(fn) == 0 {
 		cb <- &KeyValue{"result": "error", "message": "Invalid document uri"}
 		return
 	}
+	languageID, err := s.client.languageID(textDocument)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	textDocument.LanguageID = languageID
 
-	go s.request("textDocument/documentSymbol", DidOpenTextDocumentParams{textDocument})
-	if _, ok := s.openFiles[fn]; ok {
+	s.Lock()
+	if _, ok := s.openFiles[fn]; ok && options.Transient {
+		s.Unlock()
+		cb <- &KeyValue{"result": "ok", "message": "already opened"}
+		return
+	}
+	go s.backgroundRequest("textDocument/documentSymbol", DidOpenTextDocumentParams{textDocument})
+	sessions := map[string]bool{}
+	if doc, ok := s.openFiles[fn]; ok {
+		sessions = doc.sessions
 		// cb <- &KeyValue{"result": "ok", "message": "already opened"}
 		// return
 		s.client.notification("textDocument/didClose", DocumentSymbolParams{TextDocumentIdentifier{
 			DocumentURI(fn),
 		}})
 		time.Sleep(100 * time.Millisecond)
+	} else if s.prewarming[fn] {
+		// the editor takes the document over from prewarm
+		delete(s.prewarming, fn)
+		s.client.notification("textDocument/didClose", DocumentSymbolParams{TextDocumentIdentifier{
+			DocumentURI(fn),
+		}})
 	}
-	s.openFiles[fn] = time.Now()
+	sessions[mr.Session] = true
+	now := time.Now()
+	s.openFiles[fn] = &openDocument{item: textDocument, opened: now, accessed: now, sessions: sessions}
 	s.client.notification("textDocument/didOpen", DidOpenTextDocumentParams{textDocument})
+	s.evictOpenFiles()
+	// other requests for open documents shouldn't wait for diagnostics
+	s.Unlock()
 	Log.Trace("waiting for diagnostics for " + fn)
-	s.wait("diagnostics."+fn, cb)
+	s.waitPublished(fn, mr.Session, cb)
+}
+
+// document returns the open document with the given uri.
+func (s *mateServer) document(uri DocumentURI) (TextDocumentItem, bool) {
+	s.Lock()
+	defer s.Unlock()
+	doc, ok := s.openFiles[string(uri)]
+	if !ok {
+		return TextDocumentItem{}, false
+	}
+	return doc.item, true
 }
 
 func (s *mateServer) onDidClose(mr mateRequest, cb kvChan) {
@@ -199,73 +672,308 @@ func (s *mateServer) onDidClose(mr mateRequest, cb kvChan) {
 		cb <- &KeyValue{"result": "error", "message": "Invalid document uri"}
 		return
 	}
+	// the document stays open for the other editor windows
+	if _, unused := s.releaseDocument(fn, mr.Session); !unused {
+		cb <- &KeyValue{"result": "ok", "message": "open in another session"}
+		return
+	}
 	s.client.notification("textDocument/didClose", DocumentSymbolParams{textDocument})
-	delete(s.openFiles, fn)
 
 	cb <- &KeyValue{"result": "ok"}
 }
 
-func (s *mateServer) onInitialize(mr mateRequest, cb kvChan) {
+// onDidCloseBatch closes all the documents of the uri array at once. Documents
+// that aren't open in the session are skipped.
+func (s *mateServer) onDidCloseBatch(mr mateRequest, cb kvChan) {
+	uris := []DocumentURI{}
+	if err := json.Unmarshal(mr.Body, &uris); err != nil {
+		cb <- &KeyValue{"result": "error", "message": err.Error()}
+		return
+	}
+
 	s.Lock()
 	defer s.Unlock()
-	if s.initialized {
-		cb <- &KeyValue{"result": "ok", "message": "already initialized"}
+	closed := 0
+	for _, uri := range uris {
+		released, unused := s.releaseDocument(string(uri), mr.Session)
+		if !released {
+			continue
+		}
+		if unused {
+			s.client.notification("textDocument/didClose", DocumentSymbolParams{TextDocumentIdentifier{uri}})
+		}
+		closed++
+	}
+
+	cb <- &KeyValue{"result": KeyValue{"requested": len(uris), "closed": closed}}
+}
+
+// onValidate makes the server re-validate an open document by resending its
+// content with a bumped version, and waits for the fresh diagnostics.
+func (s *mateServer) onValidate(mr mateRequest, cb kvChan) {
+	textDocument := TextDocumentIdentifier{}
+	if err := json.Unmarshal(mr.Body, &textDocument); err != nil {
+		cb <- &KeyValue{"result": "error", "message": err.Error()}
+		return
+	}
+
+	fn := string(textDocument.URI)
+	s.Lock()
+	doc, ok := s.openFiles[fn]
+	if !ok {
+		s.Unlock()
+		cb <- &KeyValue{"result": "error", "message": "Document is not open"}
+		return
+	}
+	doc.item.Version++
+	params := DidChangeTextDocumentParams{
+		TextDocument: VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: textDocument,
+			Version:                doc.item.Version,
+		},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: doc.item.Text}},
+	}
+	s.Unlock()
+
+	s.client.notification("textDocument/didChange", params)
+	Log.Trace("waiting for diagnostics for " + fn)
+	s.waitPublished(fn, mr.Session, cb)
+}
+
+func (s *mateServer) onDidChangeConfiguration(mr mateRequest, cb kvChan) {
+	params := DidChangeConfigurationParams{}
+	if err := json.Unmarshal(mr.Body, &params); err != nil {
+		cb <- &KeyValue{"result": "error", "message": err.Error()}
+		return
+	}
+	settings, ok := toKeyValue(params.Settings)
+	if !ok {
+		cb <- &KeyValue{"result": "error", "message": "Invalid settings"}
+		return
+	}
+	// accept both {"intelephense": {...}} and the bare section
+	if section, ok := toKeyValue(settings["intelephense"]); ok {
+		settings = section
+	}
+
+	s.configLock.Lock()
+	s.config = mergeConfig(s.config, settings)
+	cfg := s.config
+	s.configLock.Unlock()
+
+	s.client.notification("workspace/didChangeConfiguration", DidChangeConfigurationParams{
+		KeyValue{"intelephense": serverConfig(cfg)},
+	})
+	cb <- &KeyValue{"result": cfg}
+}
+
+// onGetConfiguration replies with the settings served to the language server
+// for workspace/configuration, or the dotted "section" of them.
+func (s *mateServer) onGetConfiguration(mr mateRequest, cb kvChan) {
+	params := struct {
+		Section string `json:"section"`
+	}{}
+	if len(mr.Body) > 0 {
+		if err := json.Unmarshal(mr.Body, &params); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+	}
+	cfg := s.servedConfiguration()
+	if len(params.Section) == 0 {
+		cb <- &KeyValue{"result": cfg}
+		return
+	}
+	value, ok := configSection(cfg, params.Section)
+	if !ok {
+		cb <- &KeyValue{"result": "error", "message": "unknown section " + params.Section}
+		return
+	}
+	cb <- &KeyValue{"result": value}
+}
+
+// configuration returns the settings the editor configured.
+func (s *mateServer) configuration() KeyValue {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	return s.config
+}
+
+// servedConfiguration returns the settings currently served to the language
+// server.
+func (s *mateServer) servedConfiguration() KeyValue {
+	return serverConfig(s.configuration())
+}
+
+// setCapabilities stores the capabilities from the initialize result.
+func (s *mateServer) setCapabilities(result interface{}) {
+	raw, ok := result.(json.RawMessage)
+	if !ok {
+		return
+	}
+	initResult := InitializeResult{}
+	if err := json.Unmarshal(raw, &initResult); err != nil {
+		Log.Warn(err)
 		return
 	}
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	s.capabilities = initResult.Capabilities
+}
+
+// serverCapabilities returns the capabilities of the language server.
+func (s *mateServer) serverCapabilities() ServerCapabilities {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	return s.capabilities
+}
+
+func (s *mateServer) onInitialize(mr mateRequest, cb kvChan) {
+	s.Lock()
+	defer s.Unlock()
 	params := KeyValue{}
 	if err := json.Unmarshal(mr.Body, &params); err != nil {
 		cb <- &KeyValue{"result": "error", "message": err.Error()}
 		return
 	}
+	// editor preferences may change without restarting the language server
+	s.setClientOptions(mr.Session, parseClientOptions(params))
+	// a response that arrived after a timed out initialize completes it
+	if s.initialized || s.status.isInitialized() {
+		s.initialized = true
+		cb <- &KeyValue{"result": "ok", "message": "already initialized"}
+		return
+	}
 
-	timer := time.NewTimer(10 * time.Second)
+	// "stubs" replaces the built-in list, "extraStubs" adds framework stubs to it
+	s.configLock.Lock()
+	s.config = withStubs(s.config, params.strings("stubs", nil), params.strings("extraStubs", nil))
+	s.configLock.Unlock()
+
+	storagePath := s.storageDir(params.string("storage", "/tmp/intelephense/"))
+	params["storage"] = storagePath
+
+	// "initTimeout" in milliseconds overrides the -init-timeout flag
+	timeout := time.Duration(params.int("initTimeout", int(*initTimeout/time.Millisecond))) * time.Millisecond
+	timer := time.NewTimer(timeout)
 	var canceled = make(chan struct{})
-	s.initialize(params)
+	var failed = make(chan error, 1)
 
 	// subscribe to initialized response and wait for it
 	events.Once("initialized", func(event string, payload ...interface{}) {
 		canceled <- struct{}{}
 		timer.Stop()
 		s.initialized = true
-		cb <- &KeyValue{"result": "ok"}
+		cb <- &KeyValue{"result": "ok", "storagePath": storagePath}
 	})
+	events.Once("initializeFailed", func(event string, payload ...interface{}) {
+		failed <- payload[0].(error)
+	})
+	if s.status.startInitialize() {
+		if err := s.initialize(params); err != nil {
+			s.status.setInitialized(false)
+			failed <- err
+		}
+	} else {
+		Log.Info("Initialize is still in progress, waiting for the response")
+	}
 
 	// block until got response for initialized or timeout
 	select {
 	case <-timer.C:
-		s.initialized = true
+		// the server may still be indexing a big project, a late response
+		// is handled by the request listener and a retry picks it up
+		Log.WithField("timeout", timeout).Warn("Initialize timed out")
 		events.RemoveAllListeners("initialized")
-		s.client.notification("initialized", KeyValue{}) // notify server that we are ready
-		s.client.notification("workspace/didChangeConfiguration", DidChangeConfigurationParams{
-			KeyValue{"intelephense.files.maxSize": 3000000},
-		})
-		cb <- &KeyValue{"result": "ok"}
-		return
+		events.RemoveAllListeners("initializeFailed")
+		replyError(cb, &LSPError{Code: RequestTimedOut, Message: "initialize timed out after " + timeout.String()})
+	case err := <-failed:
+		timer.Stop()
+		events.RemoveAllListeners("initialized")
+		events.RemoveAllListeners("initializeFailed")
+		Log.WithField("error", err).Error("Initialize failed")
+		replyError(cb, err)
 	case <-canceled:
+		events.RemoveAllListeners("initializeFailed")
+		s.lastInitialize = &mr
 	}
 }
 
+// storageDir returns the usable directory for the configured storage path.
+// Each path is checked once, so retries reuse the same fallback directory.
+// Must be called with the lock held.
+func (s *mateServer) storageDir(dir string) string {
+	if storagePath, ok := s.storageDirs[dir]; ok {
+		return storagePath
+	}
+	if s.storageDirs == nil {
+		s.storageDirs = make(map[string]string)
+	}
+	storagePath := writableDir(dir)
+	s.storageDirs[dir] = storagePath
+	return storagePath
+}
+
 func (s *mateServer) startListeners() {
 	defer s.handlePanic(mateRequest{})
 
-	events.On("request.1", func(event string, payload ...interface{}) {
+	events.On("request."+strconv.Itoa(initializeRequestID), func(event string, payload ...interface{}) {
+		if err, ok := payload[1].(*LSPError); ok && err != nil {
+			s.status.setInitialized(false)
+			events.Emit("initializeFailed", err)
+			return
+		}
+		Log.Info("Language server initialized")
+		s.setCapabilities(payload[0])
 		s.client.notification("initialized", KeyValue{})
 		s.client.notification("workspace/didChangeConfiguration", DidChangeConfigurationParams{
 			KeyValue{"intelephense.files.maxSize": 3000000},
 		})
+		s.status.setInitialized(true)
 		events.Emit("initialized")
+		s.checkReady()
 	})
-	// timer := time.NewTicker(30 * time.Second)
+	timer := time.NewTicker(30 * time.Second)
 
 	for {
 		select {
 		case r := <-s.client.responseChan:
 			switch r.Method {
 			case "restart":
+				s.Lock()
 				s.initialized = false
-				s.openFiles = make(map[string]time.Time)
+				documents := s.openFiles
+				s.openFiles = make(map[string]*openDocument)
+				s.prewarming = make(map[string]bool)
+				restored := make(chan struct{})
+				s.restored = restored
+				s.Unlock()
+				s.status.reset()
+				s.abandonPending()
+				if s.replayOnRestart() {
+					go s.reinitialize(documents, restored)
+				}
 			case "client/registerCapability":
 				s.client.notification("client/registerCapability", KeyValue{})
+			case "workspace/applyEdit":
+				s.client.response(r.ID, r.Method, s.applyEdit(r.Params))
+			case "window/workDoneProgress/create":
+				s.client.response(r.ID, r.Method, nil)
+			case "$/progress":
+				s.status.progress(r.Params)
+				s.forwardProgress(r.Params)
+				s.checkReady()
+			case "$/logTrace":
+				s.logTrace(r.Params)
+			case "indexingStarted":
+				// intelephense reports indexing with its own notifications
+				s.status.indexingStarted(indexingToken)
+			case "indexingEnded":
+				s.status.indexingEnded(indexingToken)
+				s.checkReady()
+			case "serenata/didProgressIndexing":
+				s.status.serenataProgress(r.Params)
+				s.checkReady()
 			case "textDocument/publishDiagnostics":
 				jsParams, _ := json.Marshal(r.Params)
 				params := PublishDiagnosticsParams{}
@@ -273,160 +981,44 @@ func (s *mateServer) startListeners() {
 					Log.Warn(err)
 				} else {
 					Log.Debug("diagnostics." + string(params.URI))
+					s.diagnostics.publish(params.URI, params.Diagnostics)
+					go s.fanOutDiagnostics(params.URI, params.Diagnostics)
 					events.Emit("diagnostics."+string(params.URI), params.Diagnostics)
 				}
 			case "workspace/configuration":
-				// temporary
-				cfg := KeyValue{
-					"files": KeyValue{
-						"maxSize":      300000,
-						"associations": []string{"*.php", "*.phtml"},
-						"exclude": []string{
-							"**/.git/**",
-							"**/.svn/**",
-							"**/.hg/**",
-							"**/CVS/**",
-							"**/.DS_Store/**",
-							"**/node_modules/**",
-							"**/bower_components/**",
-							"**/vendor/**/{Test,test,Tests,tests}/**",
-							"**/.git",
-							"**/.svn",
-							"**/.hg",
-							"**/CVS",
-							"**/.DS_Store",
-							"**/nova/tests/**",
-							"**/faker/**",
-							"**/*.log",
-							"**/*.log*",
-							"**/*.min.*",
-							"**/dist",
-							"**/coverage",
-							"**/build/*",
-							"**/nova/public/*",
-							"**/public/*",
-						},
-					},
-					"stubs": []string{
-						"apache",
-						"bcmath",
-						"bz2",
-						"calendar",
-						"com_dotnet",
-						"Core",
-						"ctype",
-						"curl",
-						"date",
-						"dba",
-						"dom",
-						"enchant",
-						"exif",
-						"fileinfo",
-						"filter",
-						"fpm",
-						"ftp",
-						"gd",
-						"hash",
-						"iconv",
-						"imap",
-						"interbase",
-						"intl",
-						"json",
-						"ldap",
-						"libxml",
-						"mbstring",
-						"mcrypt",
-						"meta",
-						"mssql",
-						"mysqli",
-						"oci8",
-						"odbc",
-						"openssl",
-						"pcntl",
-						"pcre",
-						"PDO",
-						"pdo_ibm",
-						"pdo_mysql",
-						"pdo_pgsql",
-						"pdo_sqlite",
-						"pgsql",
-						"Phar",
-						"posix",
-						"pspell",
-						"readline",
-						"recode",
-						"Reflection",
-						"regex",
-						"session",
-						"shmop",
-						"SimpleXML",
-						"snmp",
-						"soap",
-						"sockets",
-						"sodium",
-						"SPL",
-						"sqlite3",
-						"standard",
-						"superglobals",
-						"sybase",
-						"sysvmsg",
-						"sysvsem",
-						"sysvshm",
-						"tidy",
-						"tokenizer",
-						"wddx",
-						"xml",
-						"xmlreader",
-						"xmlrpc",
-						"xmlwriter",
-						"Zend OPcache",
-						"zip",
-						"zlib",
-					},
-					"completion": KeyValue{
-						"insertUseDeclaration":                    true,
-						"fullyQualifyGlobalConstantsAndFunctions": false,
-						"triggerParameterHints":                   true,
-						"maxItems":                                100,
-					},
-					"format": KeyValue{
-						"enable": false,
-					},
-					"environment": KeyValue{
-						"documentRoot": "",
-						"includePaths": []string{},
-					},
-					"runtime":   "",
-					"maxMemory": 0,
-					"telemetry": KeyValue{"enabled": false},
-					"trace": KeyValue{
-						"server": "verbose",
-					},
-				}
+				cfg := s.servedConfiguration()
 				s.client.response(r.ID, "workspace/configuration", []KeyValue{
 					cfg,
 					cfg,
 				})
 			default:
-				events.Emit("request."+strconv.Itoa(r.ID), r.Result)
+				// server requests and notifications have their own ids
+				if len(r.Method) == 0 && s.resolve(r) {
+					break
+				}
+				events.Emit("request."+strconv.Itoa(r.ID), r.Result, r.Error)
 			}
-			// case <-timer.C:
-			// go s.cleanOpenFiles()
+		case <-timer.C:
+			go s.cleanOpenFiles()
 		}
 	}
 }
 
+// cleanOpenFiles closes the documents no request used for idleTimeout. The
+// sessions that had them open are told, so they can open them again.
 func (s *mateServer) cleanOpenFiles() {
 	s.Lock()
 	defer s.Unlock()
-	if len(s.openFiles) == 0 {
+	if len(s.openFiles) == 0 || s.idleTimeout <= 0 {
 		return
 	}
 	Log.Trace("Cleaning open files...")
-	for fn, openTime := range s.openFiles {
-		if time.Since(openTime).Seconds() > cacheTime.Seconds() {
+	for fn, doc := range s.openFiles {
+		// idle documents only, requests keep the others open
+		if time.Since(doc.accessed) > s.idleTimeout {
 			delete(s.openFiles, fn)
 			s.client.notification("textDocument/didClose", DocumentSymbolParams{TextDocumentIdentifier{DocumentURI(fn)}})
+			s.stream.broadcastTo(doc.sessions, "closed", KeyValue{"uri": fn})
 		}
 	}
 }
@@ -440,11 +1032,16 @@ func (s *mateServer) initialize(params KeyValue) error {
 	storagePath := params.string("storage", "/tmp/intelephense/")
 	name := params.string("name", "phpProject")
 	Log.WithField("dir", dir).WithField("name", name).Info("Initialize")
-	s.client.request(1, "initialize", InitializeParams{
+	initOptions := KeyValue{"storagePath": storagePath, "clearCache": true, "isVscode": true, "licenceKey": license}
+	// server specific options, they override the built-in ones
+	if extra, ok := toKeyValue(params["initializationOptions"]); ok {
+		initOptions = mergeConfig(initOptions, extra)
+	}
+	s.client.request(initializeRequestID, "initialize", InitializeParams{
 		ProcessID:             os.Getpid(),
 		RootURI:               DocumentURI("file://" + dir),
 		RootPath:              dir,
-		InitializationOptions: KeyValue{"storagePath": storagePath, "clearCache": true, "isVscode": true, "licenceKey": license},
+		InitializationOptions: initOptions,
 		Capabilities: KeyValue{
 			"textDocument": KeyValue{
 				"synchronization": KeyValue{
@@ -458,9 +1055,9 @@ func (s *mateServer) initialize(params KeyValue) error {
 					"dynamicRegistration": true,
 					"contextSupport":      true,
 					"completionItem": KeyValue{
-						"snippetSupport":          true,
+						"snippetSupport":          params.bool("snippetSupport", true),
 						"commitCharactersSupport": true,
-						"documentationFormat":     []string{"markdown", "plaintext"},
+						"documentationFormat":     documentationFormat(params),
 						"deprecatedSupport":       true,
 						"preselectSupport":        true,
 					},
@@ -479,6 +1076,23 @@ func (s *mateServer) initialize(params KeyValue) error {
 						"parameterInformation": KeyValue{"labelOffsetSupport": true},
 					},
 				},
+				"codeAction": KeyValue{
+					"dynamicRegistration": true,
+					"codeActionLiteralSupport": KeyValue{
+						"codeActionKind": KeyValue{
+							"valueSet": []CodeActionKind{
+								"",
+								CAKQuickFix,
+								CAKRefactor,
+								CAKRefactorExtract,
+								CAKRefactorInline,
+								CAKRefactorRewrite,
+								CAKSource,
+								CAKSourceOrganizeImports,
+							},
+						},
+					},
+				},
 				"codeLens":         KeyValue{"dynamicRegistration": true},
 				"formatting":       KeyValue{"dynamicRegistration": true},
 				"rangeFormatting":  KeyValue{"dynamicRegistration": true},
@@ -507,6 +1121,9 @@ func (s *mateServer) initialize(params KeyValue) error {
 					"linkSupport":         true,
 				},
 			},
+			"window": KeyValue{
+				"workDoneProgress": true,
+			},
 
 			"workspace": KeyValue{
 				"applyEdit": true,
@@ -538,6 +1155,32 @@ func (s *mateServer) initialize(params KeyValue) error {
 	return nil
 }
 
+// shutdown asks the language server to shut down and exit.
+func (s *mateServer) shutdown() {
+	if _, err := s.call("shutdown", nil); err != nil {
+		Log.Warn(err)
+	}
+	s.client.notification("exit", nil)
+}
+
+// watchParent exits the bridge once the editor process is gone, so neither the
+// bridge nor the language server are left running orphaned.
+func (s *mateServer) watchParent(pid int) {
+	parent := pid < 0
+	if parent {
+		pid = os.Getppid()
+	}
+	Log.WithField("pid", pid).Info("Watching editor process")
+	for range time.Tick(2 * time.Second) {
+		// orphaned processes are adopted by init or a subreaper
+		if (parent && os.Getppid() != pid) || !processAlive(pid) {
+			Log.WithField("pid", pid).Warn("Editor process is gone, exiting")
+			s.shutdown()
+			os.Exit(0)
+		}
+	}
+}
+
 func (s *mateServer) handlePanic(mr mateRequest) {
 	if err := recover(); err != nil {
 		Log.WithField("method", mr.Method).WithField("bt", string(debug.Stack())).Error("Recovered from:", err)
@@ -546,8 +1189,29 @@ func (s *mateServer) handlePanic(mr mateRequest) {
 
 func startServer(client *lspClient, port string) {
 	Log.Info("Running webserver on port " + port)
-	server := mateServer{client: client, openFiles: make(map[string]time.Time), requestID: 1, initialized: false}
+	server := mateServer{
+		client:           client,
+		openFiles:        make(map[string]*openDocument),
+		prewarming:       make(map[string]bool),
+		requestID:        initializeRequestID,
+		pending:          make(map[int]*pendingRequest),
+		completions:      make(map[completionKey]int),
+		completionCache:  make(map[string]cachedCompletion),
+		workDone:         make(map[string]workDoneRequest),
+		rawResults:       make(map[kvChan][]json.RawMessage),
+		hangThreshold:    *hangs,
+		maxOpenFiles:     *maxOpen,
+		idleTimeout:      *idleTime,
+		batchConcurrency: *batchWorkers,
+		initialized:      false,
+		config:           defaultConfig(),
+		traces:           traceStore{level: traceOff},
+		options:          make(map[string]clientOptions),
+	}
 	go server.startListeners()
+	if *ppid != 0 {
+		go server.watchParent(*ppid)
+	}
 
 	Log.Fatal(http.ListenAndServe(":"+port, &server))
 }
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextRequestID(t *testing.T) {
+	s := mateServer{requestID: initializeRequestID, pending: make(map[int]*pendingRequest)}
+	if id := s.nextRequestID(); id != firstRequestID {
+		t.Errorf("first request id expected %d, but got %d", firstRequestID, id)
+	}
+
+	// a reset counter must not hand out the initialize id again
+	s.requestID = 0
+	if id := s.nextRequestID(); id == initializeRequestID {
+		t.Errorf("request id %d collides with initialize after reset", id)
+	}
+
+	// wrap around skips the initialize id and ids still pending
+	s.requestID = maxRequestID
+	s.pending[firstRequestID] = &pendingRequest{id: firstRequestID}
+	if id := s.nextRequestID(); id != firstRequestID+1 {
+		t.Errorf("request id after wrap around expected %d, but got %d", firstRequestID+1, id)
+	}
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", defaultHTTPTimeout},
+		{"500", 500 * time.Millisecond},
+		{"60000", time.Minute},
+		{"3600000", maxHTTPTimeout},
+		{"-1", defaultHTTPTimeout},
+		{"soon", defaultHTTPTimeout},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		if len(test.header) > 0 {
+			r.Header.Set("X-Timeout", test.header)
+		}
+		if got := httpTimeout(r); got != test.want {
+			t.Errorf("X-Timeout %q, expected %s, but got %s", test.header, test.want, got)
+		}
+	}
+}
+
+func TestReplyWithin(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		wait    time.Duration
+		want    time.Duration
+	}{
+		{0, 0, defaultHTTPTimeout - maxReplyMargin},
+		{defaultHTTPTimeout, 15 * time.Second, 15 * time.Second},
+		{defaultHTTPTimeout, 30 * time.Second, defaultHTTPTimeout - maxReplyMargin},
+		{5 * time.Second, 15 * time.Second, 4500 * time.Millisecond},
+		{time.Second, 0, 900 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		mr := mateRequest{Timeout: test.timeout}
+		if got := mr.replyWithin(test.wait); got != test.want {
+			t.Errorf("Waiting %s with a %s time out, expected %s, but got %s", test.wait, test.timeout, test.want, got)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	s := mateServer{}
+	tests := []struct {
+		errors []error
+		sent   int
+	}{
+		{[]error{nil}, 1},
+		{[]error{&LSPError{Code: ContentModified}, nil}, 2},
+		{[]error{&LSPError{Code: ContentModified}, &LSPError{Code: ContentModified}}, 2},
+		// replay is off unless the editor opted in
+		{[]error{&LSPError{Code: ServerRestarted}, nil}, 1},
+		{[]error{&LSPError{Code: InvalidParams}, nil}, 1},
+	}
+
+	for _, test := range tests {
+		sent := 0
+		s.retry("textDocument/hover", clientOptions{}, func() (json.RawMessage, error) {
+			err := test.errors[sent]
+			sent++
+			return nil, err
+		})
+		if sent != test.sent {
+			t.Errorf("Errors %v, expected %d requests, but got %d", test.errors, test.sent, sent)
+		}
+	}
+}
+
+func TestExpire(t *testing.T) {
+	s := mateServer{pending: make(map[int]*pendingRequest), hangThreshold: 2}
+	expire := func(watched bool) {
+		p := &pendingRequest{id: s.nextRequestID(), method: "textDocument/hover", done: make(chan *response, 1), watched: watched}
+		s.pending[p.id] = p
+		s.expire(p.id)
+		if r := <-p.done; !isErrorCode(r.Error, RequestTimedOut) {
+			t.Errorf("Expected a time out error, but got %v", r.Error)
+		}
+	}
+
+	expire(false)
+	if s.timeouts != 0 {
+		t.Errorf("Background request time outs must not count, got %d", s.timeouts)
+	}
+	s.status.indexingStarted(indexingToken)
+	expire(true)
+	if s.timeouts != 0 {
+		t.Errorf("Time outs while indexing must not count, got %d", s.timeouts)
+	}
+	s.status.indexingEnded(indexingToken)
+	expire(true)
+	if s.timeouts != 1 {
+		t.Errorf("Expected 1 counted time out, but got %d", s.timeouts)
+	}
+}
+
+func TestStorageDir(t *testing.T) {
+	f, err := ioutil.TempFile("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	s := mateServer{}
+	// a directory below a file can't be created
+	unusable := filepath.Join(f.Name(), "intelephense")
+	fallback := s.storageDir(unusable)
+	defer os.RemoveAll(fallback)
+	if fallback == unusable {
+		t.Fatalf("Expected a fallback for %s", unusable)
+	}
+	if again := s.storageDir(unusable); again != fallback {
+		t.Errorf("Expected the fallback %s to be reused, but got %s", fallback, again)
+	}
+}
+
+func TestRestartThenInitialize(t *testing.T) {
+	storage, err := ioutil.TempDir("", "intelephense")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storage)
+
+	out := &bufferCloser{}
+	s := mateServer{
+		client:      &lspClient{out: out, responseChan: make(chan *response)},
+		openFiles:   make(map[string]*openDocument),
+		requestID:   initializeRequestID,
+		pending:     make(map[int]*pendingRequest),
+		completions: make(map[completionKey]int),
+		config:      defaultConfig(),
+	}
+	go s.startListeners()
+
+	// a request interrupted by the restart
+	interrupted := s.request("textDocument/hover", nil)
+	s.client.responseChan <- &response{Method: "restart"}
+	if _, err := interrupted.wait(); !isErrorCode(err, ServerRestarted) {
+		t.Fatalf("Expected the pending request to be abandoned, but got %v", err)
+	}
+
+	// even a reset counter must not hand out the initialize id again
+	s.pendingLock.Lock()
+	s.requestID = 0
+	s.pendingLock.Unlock()
+	initialized := make(kvChan, 1)
+	go s.onInitialize(mateRequest{
+		Method: "initialize",
+		Body:   json.RawMessage(`{"dir":"/tmp/project","storage":"` + storage + `","initTimeout":2000}`),
+	}, initialized)
+	hover := s.request("textDocument/hover", nil)
+	if hover.id == initializeRequestID {
+		t.Fatalf("Request got the initialize id %d", hover.id)
+	}
+	for !strings.Contains(out.String(), `"method":"initialize"`) {
+		time.Sleep(time.Millisecond)
+	}
+
+	s.client.responseChan <- &response{ID: initializeRequestID, Result: json.RawMessage(`{"capabilities":{}}`)}
+	s.client.responseChan <- &response{ID: hover.id, Result: json.RawMessage(`{"contents":"hover"}`)}
+	if result, err := hover.wait(); err != nil || string(result) != `{"contents":"hover"}` {
+		t.Errorf("Expected the hover result, but got %s (%v)", result, err)
+	}
+	select {
+	case reply := <-initialized:
+		if (*reply)["result"] != "ok" {
+			t.Errorf("Expected initialize to succeed, but got %v", *reply)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("initialize didn't get its response")
+	}
+	for deadline := time.Now().Add(time.Second); !s.status.isInitialized(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the server to be initialized")
+		}
+	}
+}
+
+func TestGetConfigurationMatchesServed(t *testing.T) {
+	out := &bufferCloser{}
+	s := mateServer{
+		client: &lspClient{out: out, responseChan: make(chan *response)},
+		config: mergeConfig(defaultConfig(), KeyValue{"completion.maxItems": 20}),
+	}
+	go s.startListeners()
+
+	s.client.responseChan <- &response{ID: 7, Method: "workspace/configuration"}
+	for !strings.Contains(out.String(), `"method":"workspace/configuration"`) {
+		time.Sleep(time.Millisecond)
+	}
+	sent := out.String()
+	message := struct {
+		Result []interface{} `json:"result"`
+	}{}
+	if err := json.Unmarshal([]byte(sent[strings.Index(sent, "\r\n\r\n")+4:]), &message); err != nil || len(message.Result) == 0 {
+		t.Fatalf("Unexpected workspace/configuration response %s (%v)", sent, err)
+	}
+
+	cb := make(kvChan, 1)
+	s.onGetConfiguration(mateRequest{Method: "getConfiguration"}, cb)
+	data, _ := json.Marshal((*<-cb)["result"])
+	var got interface{}
+	json.Unmarshal(data, &got)
+	if !reflect.DeepEqual(got, message.Result[0]) {
+		t.Errorf("getConfiguration expected the served %v, but got %v", message.Result[0], got)
+	}
+}
+
+func TestCancelAll(t *testing.T) {
+	out := &bufferCloser{}
+	s := mateServer{
+		client:    &lspClient{out: out},
+		requestID: initializeRequestID,
+		pending:   make(map[int]*pendingRequest),
+	}
+	requests := []*pendingRequest{
+		s.request("textDocument/hover", nil),
+		s.request("textDocument/completion", nil),
+		s.backgroundRequest("textDocument/documentSymbol", nil),
+	}
+
+	if canceled := s.cancelAll(); canceled != len(requests) {
+		t.Errorf("Expected %d canceled requests, but got %d", len(requests), canceled)
+	}
+	for _, p := range requests {
+		if _, err := p.wait(); !isErrorCode(err, RequestCancelled) {
+			t.Errorf("Expected %s to be canceled, but got %v", p.method, err)
+		}
+		// a timer still running would expire the request later
+		if p.timer.Stop() {
+			t.Errorf("Timer of %s is still running", p.method)
+		}
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("Expected no pending requests, but got %v", s.pending)
+	}
+	if sent := strings.Count(out.String(), "$/cancelRequest"); sent != len(requests) {
+		t.Errorf("Expected %d $/cancelRequest, but sent %d", len(requests), sent)
+	}
+	if canceled := s.cancelAll(); canceled != 0 {
+		t.Errorf("Expected nothing left to cancel, but got %d", canceled)
+	}
+}
+
+func TestOnDidCloseBatch(t *testing.T) {
+	out := &bufferCloser{}
+	s := mateServer{
+		client: &lspClient{out: out},
+		openFiles: map[string]*openDocument{
+			"file:///a.php":      {sessions: map[string]bool{"one": true}},
+			"file:///b.php":      {sessions: map[string]bool{"one": true}},
+			"file:///shared.php": {sessions: map[string]bool{"one": true, "two": true}},
+			"file:///other.php":  {sessions: map[string]bool{"two": true}},
+		},
+	}
+	body := `["file:///a.php","file:///b.php","file:///shared.php","file:///other.php","file:///unknown.php"]`
+
+	cb := make(kvChan, 1)
+	s.onDidCloseBatch(mateRequest{Method: "didCloseBatch", Body: json.RawMessage(body), Session: "one"}, cb)
+	result, ok := (*<-cb)["result"].(KeyValue)
+	if !ok || result["requested"] != 5 || result["closed"] != 3 {
+		t.Errorf("Expected 3 of 5 documents closed, but got %v", result)
+	}
+	for _, fn := range []string{"file:///a.php", "file:///b.php"} {
+		if _, ok := s.openFiles[fn]; ok {
+			t.Errorf("Expected %s to be closed", fn)
+		}
+	}
+	if doc, ok := s.openFiles["file:///shared.php"]; !ok || doc.sessions["one"] || !doc.sessions["two"] {
+		t.Errorf("Expected file:///shared.php to stay open for session two only, but got %+v", doc)
+	}
+	if doc, ok := s.openFiles["file:///other.php"]; !ok || !doc.sessions["two"] {
+		t.Errorf("Expected file:///other.php of session two to be left alone")
+	}
+	// only documents no session holds anymore are closed in the server
+	sent := regexp.MustCompile(`"uri":"(file:///[a-z]+\.php)"`).FindAllStringSubmatch(out.String(), -1)
+	var closed []string
+	for _, match := range sent {
+		closed = append(closed, match[1])
+	}
+	if !reflect.DeepEqual(closed, []string{"file:///a.php", "file:///b.php"}) {
+		t.Errorf("Expected didClose for file:///a.php and file:///b.php, but got %v", closed)
+	}
+}
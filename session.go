@@ -0,0 +1,52 @@
+package main
+
+import "net/http"
+
+// sessionID identifies the editor window sending the request, so editors
+// sharing the bridge keep their own open documents. Event streams can't set
+// headers and pass it as the session query parameter instead.
+func sessionID(r *http.Request) string {
+	if session := r.Header.Get("X-Session"); len(session) > 0 {
+		return session
+	}
+	return r.URL.Query().Get("session")
+}
+
+// releaseDocument drops the session from the open document and reports
+// whether the document was open in it and whether no session holds it
+// anymore, so it should be closed in the language server. Must be called with
+// the lock held.
+func (s *mateServer) releaseDocument(fn, session string) (released, unused bool) {
+	doc, ok := s.openFiles[fn]
+	if !ok || !doc.sessions[session] {
+		return false, !ok
+	}
+	delete(doc.sessions, session)
+	if len(doc.sessions) > 0 {
+		return true, false
+	}
+	delete(s.openFiles, fn)
+	return true, true
+}
+
+// fanOutDiagnostics sends the diagnostics to the sessions that have the
+// document open, each narrowed to the sources the session wants.
+func (s *mateServer) fanOutDiagnostics(uri DocumentURI, diagnostics []Diagnostic) {
+	s.Lock()
+	var sessions map[string]bool
+	if doc, ok := s.openFiles[string(uri)]; ok {
+		sessions = make(map[string]bool, len(doc.sessions))
+		for session := range doc.sessions {
+			sessions[session] = true
+		}
+	}
+	s.Unlock()
+	if len(sessions) == 0 {
+		return
+	}
+	version, _ := s.diagnostics.latest(uri)
+	for session := range sessions {
+		filtered := filterDiagnostics(diagnostics, s.clientOptions(session).diagnosticSources)
+		s.stream.broadcastTo(map[string]bool{session: true}, "diagnostics", KeyValue{"uri": uri, "version": version, "diagnostics": filtered})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+func TestReleaseDocument(t *testing.T) {
+	s := mateServer{openFiles: map[string]*openDocument{
+		"file:///a.php": {sessions: map[string]bool{"one": true, "two": true}},
+	}}
+
+	if released, unused := s.releaseDocument("file:///a.php", "three"); released || unused {
+		t.Errorf("Session three doesn't have the document open, got released %v, unused %v", released, unused)
+	}
+	if released, unused := s.releaseDocument("file:///a.php", "one"); !released || unused {
+		t.Errorf("Session two still has the document open, got released %v, unused %v", released, unused)
+	}
+	if released, unused := s.releaseDocument("file:///a.php", "two"); !released || !unused {
+		t.Errorf("Last session released the document, got released %v, unused %v", released, unused)
+	}
+	if _, ok := s.openFiles["file:///a.php"]; ok {
+		t.Errorf("Document is still open after the last session released it")
+	}
+	if released, unused := s.releaseDocument("file:///b.php", "one"); released || !unused {
+		t.Errorf("Document isn't open, got released %v, unused %v", released, unused)
+	}
+}
+
+func TestSessionClientOptions(t *testing.T) {
+	s := mateServer{}
+	s.setClientOptions("one", parseClientOptions(KeyValue{"snippetSupport": false, "excludeCompletionKinds": []interface{}{float64(CIKKeyword)}}))
+	s.setClientOptions("two", parseClientOptions(KeyValue{"structuredHover": true}))
+
+	if one := s.clientOptions("one"); one.snippetSupport || !one.excludeKinds[CIKKeyword] || one.structuredHover {
+		t.Errorf("Session one got the options %+v", one)
+	}
+	if two := s.clientOptions("two"); !two.snippetSupport || len(two.excludeKinds) > 0 || !two.structuredHover {
+		t.Errorf("Session two got the options %+v", two)
+	}
+	if other := s.clientOptions("three"); !other.snippetSupport || other.structuredHover {
+		t.Errorf("Expected the default options for a session without initialize, but got %+v", other)
+	}
+	if s.replayOnRestart() {
+		t.Errorf("Expected no replay when no session asked for it")
+	}
+	s.setClientOptions("three", parseClientOptions(KeyValue{"replayOnRestart": true}))
+	if !s.replayOnRestart() {
+		t.Errorf("Expected replay when a session asked for it")
+	}
+}
+
+func TestForwardProgressToSession(t *testing.T) {
+	s := mateServer{workDone: make(map[string]workDoneRequest)}
+	one, two := s.stream.subscribe("one"), s.stream.subscribe("two")
+	token := s.trackWorkDone(&WorkDoneProgressParams{}, "workspace/symbol", "one")
+
+	s.forwardProgress(KeyValue{"token": token, "value": map[string]interface{}{"kind": "begin", "title": "Searching"}})
+	select {
+	case e := <-one:
+		if e.name != "progress" || e.data.(KeyValue)["token"] != token {
+			t.Errorf("Expected progress for %s, but got %+v", token, e)
+		}
+	default:
+		t.Errorf("Expected the session that sent the request to get the progress")
+	}
+	select {
+	case e := <-two:
+		t.Errorf("Expected no progress for another session, but got %+v", e)
+	default:
+	}
+}
+
+func TestSessionCompletions(t *testing.T) {
+	s := mateServer{completions: make(map[completionKey]int)}
+	uri := DocumentURI("file:///a.php")
+	if prev := s.trackCompletion(completionKey{"one", uri}, 2); prev != 0 {
+		t.Errorf("Expected no previous completion, but got %d", prev)
+	}
+	if prev := s.trackCompletion(completionKey{"two", uri}, 3); prev != 0 {
+		t.Errorf("Completion of another session would be canceled: %d", prev)
+	}
+	if prev := s.trackCompletion(completionKey{"one", uri}, 4); prev != 2 {
+		t.Errorf("Expected the superseded completion 2, but got %d", prev)
+	}
+
+	s.completionCache = map[string]cachedCompletion{
+		"one": {uri: uri, items: []CompletionItem{{Label: "strlen", Kind: CIKFunction, Detail: "one"}}},
+		"two": {uri: uri, items: []CompletionItem{{Label: "strlen", Kind: CIKFunction, Detail: "two"}}},
+	}
+	if item := s.expandCompletion("two", CompletionItem{Label: "strlen", Kind: CIKFunction}); item.Detail != "two" {
+		t.Errorf("Expected the item cached for session two, but got %+v", item)
+	}
+	if flushed := s.flushCompletion("one", ""); flushed != 1 {
+		t.Errorf("Expected 1 flushed item, but got %d", flushed)
+	}
+	if item := s.expandCompletion("two", CompletionItem{Label: "strlen", Kind: CIKFunction}); item.Detail != "two" {
+		t.Errorf("Flushing session one dropped the items of session two")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+func (s *mateServer) onSignatureHelp(params TextDocumentPositionParams, opts clientOptions, cb kvChan) {
+	result, err := s.callFor(opts, "textDocument/signatureHelp", params)
+	s.recordRaw(cb, result)
+	if err != nil {
+		replyError(cb, err)
+		return
+	}
+	if !opts.signatureHelpFallback || !emptySignatureHelp(result) {
+		cb <- &KeyValue{"result": result}
+		return
+	}
+
+	Log.WithField("position", params.Position).Debug("empty signatureHelp, falling back to hover")
+	result, err = s.callFor(opts, "textDocument/hover", params)
+	s.recordRaw(cb, result)
+	if err != nil {
+		cb <- &KeyValue{"result": nil}
+		return
+	}
+	hover := Hover{}
+	if err := json.Unmarshal(result, &hover); err != nil {
+		Log.Warn(err)
+		cb <- &KeyValue{"result": nil}
+		return
+	}
+	signature, ok := signatureFromHover(hover)
+	if !ok {
+		cb <- &KeyValue{"result": nil}
+		return
+	}
+	cb <- &KeyValue{"result": SignatureHelp{Signatures: []SignatureInformation{signature}}}
+}
+
+// emptySignatureHelp reports whether the server had no signatures to offer.
+func emptySignatureHelp(result json.RawMessage) bool {
+	help := struct {
+		Signatures []json.RawMessage `json:"signatures"`
+	}{}
+	if err := json.Unmarshal(result, &help); err != nil {
+		return true
+	}
+	return len(help.Signatures) == 0
+}
+
+// signatureFromHover builds a signature from the first function declaration
+// found in the hover contents, e.g.
+// "function str_replace($search, $replace, $subject, &$count = null): mixed { }".
+func signatureFromHover(hover Hover) (SignatureInformation, bool) {
+	for _, content := range hover.Contents {
+		for _, line := range strings.Split(content.Value, "\n") {
+			line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "<?php"))
+			open := strings.Index(line, "(")
+			if open < 0 || !strings.Contains(line[:open], "function") {
+				continue
+			}
+			params, ok := splitParameters(line[open+1:])
+			if !ok {
+				continue
+			}
+			signature := SignatureInformation{
+				Label: strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(line, "{ }"), "{")),
+			}
+			for _, param := range params {
+				signature.Parameters = append(signature.Parameters, ParameterInformation{Label: param})
+			}
+			return signature, true
+		}
+	}
+	return SignatureInformation{}, false
+}
+
+// splitParameters splits a parameter list at its top level commas, stopping
+// at the closing parenthesis. Returns false if the list is not closed.
+func splitParameters(list string) ([]string, bool) {
+	var params []string
+	depth, start := 0, 0
+	for i, ch := range list {
+		switch ch {
+		case '(', '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case ')':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			if param := strings.TrimSpace(list[start:i]); len(param) > 0 {
+				params = append(params, param)
+			}
+			return params, true
+		case ',':
+			if depth == 0 {
+				params = append(params, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return nil, false
+}
+
+// defaultSignatureTriggers are used when the server doesn't advertise its
+// signature help trigger characters.
+var defaultSignatureTriggers = []string{"(", ","}
+
+// notCalls are keywords followed by parentheses that aren't calls.
+var notCalls = map[string]bool{
+	"if": true, "elseif": true, "while": true, "for": true, "foreach": true,
+	"switch": true, "catch": true, "match": true, "array": true, "list": true,
+	"isset": true, "unset": true, "empty": true, "declare": true, "fn": true, "function": true,
+}
+
+// onCanTriggerSignatureHelp tells the editor whether signature help makes
+// sense at the position: the character before it is a trigger character and
+// it's inside the arguments of a call.
+func (s *mateServer) onCanTriggerSignatureHelp(params TextDocumentPositionParams, cb kvChan) {
+	doc, ok := s.document(params.TextDocument.URI)
+	if !ok {
+		cb <- &KeyValue{"result": "error", "message": "document is not open"}
+		return
+	}
+	triggers := defaultSignatureTriggers
+	if provider := s.serverCapabilities().SignatureHelpProvider; provider != nil {
+		triggers = append(append([]string{}, provider.TriggerCharacters...), provider.RetriggerCharacters...)
+	}
+
+	before := textBefore(doc.Text, params.Position)
+	trimmed := []rune(strings.TrimRight(before, " \t\r\n"))
+	character := ""
+	if len(trimmed) > 0 {
+		character = string(trimmed[len(trimmed)-1])
+	}
+	insideCall := insideCall(before)
+	trigger := false
+	for _, t := range triggers {
+		if t == character {
+			trigger = insideCall
+			break
+		}
+	}
+	cb <- &KeyValue{"result": KeyValue{"trigger": trigger, "insideCall": insideCall, "character": character}}
+}
+
+// textBefore returns the text of the document up to the position.
+func textBefore(text string, pos Position) string {
+	lines := strings.Split(text, "\n")
+	if pos.Line < 0 || pos.Line >= len(lines) {
+		return text
+	}
+	line := []rune(lines[pos.Line])
+	end := pos.Character
+	if end > len(line) {
+		end = len(line)
+	}
+	return strings.Join(append(lines[:pos.Line:pos.Line], string(line[:end])), "\n")
+}
+
+// insideCall reports whether the text ends within the arguments of a function
+// or method call, looking back for an unclosed parenthesis preceded by a name
+// within the current statement.
+func insideCall(text string) bool {
+	runes := []rune(text)
+	depth := 0
+	for i := len(runes) - 1; i >= 0; i-- {
+		switch runes[i] {
+		case ')', ']':
+			depth++
+		case '[':
+			// an unclosed array literal may be an argument itself
+			if depth > 0 {
+				depth--
+			}
+		case '(':
+			if depth > 0 {
+				depth--
+				continue
+			}
+			name := []rune(strings.TrimRight(string(runes[:i]), " \t"))
+			end := len(name)
+			for end > 0 && isWordChar(name[end-1]) {
+				end--
+			}
+			word := string(name[end:])
+			if len(word) == 0 || notCalls[strings.ToLower(word)] {
+				return false
+			}
+			// a function declaration, not a call
+			return !strings.HasSuffix(strings.TrimRight(string(name[:end]), " \t"), "function")
+		case ';', '{', '}':
+			if depth == 0 {
+				return false
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInsideCall(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"<?php\nstrlen(", true},
+		{"<?php\n$this->foo($a, ", true},
+		{"<?php\nfoo(bar(1), [1, 2], ", true},
+		{"<?php\nfoo([1, ", true},
+		{"<?php\nfoo(1);\n", false},
+		{"<?php\nif (", false},
+		{"<?php\nfunction foo(", false},
+		{"<?php\nfoo(function () {\n", false},
+		{"<?php\n$a = (", false},
+	}
+
+	for _, test := range tests {
+		if got := insideCall(test.text); got != test.want {
+			t.Errorf("insideCall(%q) expected %v, but got %v", test.text, test.want, got)
+		}
+	}
+}
+
+func TestTextBefore(t *testing.T) {
+	text := "<?php\nfoo($a, $b);\n"
+	if got := textBefore(text, Position{Line: 1, Character: 7}); got != "<?php\nfoo($a," {
+		t.Errorf("Expected text up to the position, but got %q", got)
+	}
+	if got := textBefore(text, Position{Line: 1, Character: 100}); got != "<?php\nfoo($a, $b);" {
+		t.Errorf("Expected the whole line for a position past its end, but got %q", got)
+	}
+}
+
+func TestSignatureFromHover(t *testing.T) {
+	tests := []struct {
+		contents string
+		label    string
+		params   []string
+		ok       bool
+	}{
+		{"```php\n<?php\nfunction str_replace($search, $replace, $subject, &$count = null): mixed { }\n```",
+			"function str_replace($search, $replace, $subject, &$count = null): mixed",
+			[]string{"$search", "$replace", "$subject", "&$count = null"}, true},
+		// parentheses, brackets and braces in defaults don't split parameters
+		{"<?php\npublic function map(callable $fn = null, array $keys = array(1, 2), $opts = ['a' => [1, 2]]) {",
+			"public function map(callable $fn = null, array $keys = array(1, 2), $opts = ['a' => [1, 2]])",
+			[]string{"callable $fn = null", "array $keys = array(1, 2)", "$opts = ['a' => [1, 2]]"}, true},
+		{"<?php\nfunction time(): int { }", "function time(): int", nil, true},
+		// the first declaration wins over the call examples
+		{"Returns the length.\n\nstrlen($string)\n\n<?php\nfunction strlen(string $string): int { }",
+			"function strlen(string $string): int", []string{"string $string"}, true},
+		{"<?php\nfunction broken($a, array(1", "", nil, false},
+		{"Just a description", "", nil, false},
+	}
+
+	for _, test := range tests {
+		signature, ok := signatureFromHover(Hover{Contents: []MarkedString{{Language: "php", Value: test.contents}}})
+		if ok != test.ok || signature.Label != test.label {
+			t.Errorf("Signature from %q, expected %q (%v), but got %q (%v)", test.contents, test.label, test.ok, signature.Label, ok)
+			continue
+		}
+		var params []string
+		for _, param := range signature.Parameters {
+			params = append(params, param.Label)
+		}
+		if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("Signature from %q, expected parameters %q, but got %q", test.contents, test.params, params)
+		}
+	}
+}
+
+func TestSplitParameters(t *testing.T) {
+	tests := []struct {
+		list   string
+		params []string
+		ok     bool
+	}{
+		{"$a, $b)", []string{"$a", "$b"}, true},
+		{") : void", nil, true},
+		{"  )", nil, true},
+		{"$cb = function ($x) { return $x; }, $y)", []string{"$cb = function ($x) { return $x; }", "$y"}, true},
+		{"$a = [1, [2, 3]], $b = (1 + 2))", []string{"$a = [1, [2, 3]]", "$b = (1 + 2)"}, true},
+		{"$a, $b", nil, false},
+		{"$a = array(1, 2", nil, false},
+	}
+
+	for _, test := range tests {
+		params, ok := splitParameters(test.list)
+		if ok != test.ok || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("Split %q, expected %q (%v), but got %q (%v)", test.list, test.params, test.ok, params, ok)
+		}
+	}
+}
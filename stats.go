@@ -0,0 +1,22 @@
+package main
+
+// onIndexStats reports what the bridge knows about the index and the
+// language server process: the index state, the files indexed as reported
+// by the indexing progress, and the memory of the server when the platform
+// tells.
+func (s *mateServer) onIndexStats(cb kvChan) {
+	s.Lock()
+	openFiles := len(s.openFiles)
+	s.Unlock()
+
+	stats := s.status.indexState()
+	stats["openFiles"] = openFiles
+	if pid := s.client.pid(); pid > 0 {
+		if memory, err := processMemory(pid); err == nil {
+			stats["memory"] = memory
+		} else {
+			Log.WithField("pid", pid).Debug(err)
+		}
+	}
+	cb <- &KeyValue{"result": stats}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// indexingToken stands in for a progress token when the server reports
+// indexing with its own notifications.
+const indexingToken = "indexing"
+
+// serverStatus tracks whether the language server is able to serve accurate
+// results: initialize completed and the initial indexing finished.
+type serverStatus struct {
+	initialized bool
+	// initializing is set while the initialize request awaits its response
+	initializing bool
+	indexed      bool
+	// indexing holds the active indexing progress tokens
+	indexing map[string]bool
+	// indexingSince is when the current indexing started, indexedIn how
+	// long the last one took
+	indexingSince time.Time
+	indexedIn     time.Duration
+	// indexedFiles and totalFiles count the files of the current or last
+	// indexing, when the server reports them
+	indexedFiles int
+	totalFiles   int
+	// initializeSince is when initialize was sent, initializedIn how long
+	// the server took to answer it
+	initializeSince time.Time
+	initializedIn   time.Duration
+	// announced is set once readiness has been reported
+	announced bool
+	sync.Mutex
+}
+
+type progressParams struct {
+	Token interface{}   `json:"token"`
+	Value progressValue `json:"value"`
+}
+
+type progressValue struct {
+	Kind       string `json:"kind"`
+	Title      string `json:"title,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Percentage int    `json:"percentage,omitempty"`
+}
+
+func (st *serverStatus) setInitialized(initialized bool) {
+	st.Lock()
+	defer st.Unlock()
+	if initialized && st.initializing {
+		st.initializedIn = time.Since(st.initializeSince)
+	}
+	st.initialized = initialized
+	st.initializing = false
+}
+
+func (st *serverStatus) isInitialized() bool {
+	st.Lock()
+	defer st.Unlock()
+	return st.initialized
+}
+
+// isIndexing reports whether the server is indexing right now.
+func (st *serverStatus) isIndexing() bool {
+	st.Lock()
+	defer st.Unlock()
+	return len(st.indexing) > 0
+}
+
+// startInitialize marks the initialize request as sent. It returns false if
+// an earlier one is still waiting for its response.
+func (st *serverStatus) startInitialize() bool {
+	st.Lock()
+	defer st.Unlock()
+	if st.initializing {
+		return false
+	}
+	st.initializing = true
+	st.initializeSince = time.Now()
+	return true
+}
+
+// reset forgets everything after the language server was restarted.
+func (st *serverStatus) reset() {
+	st.Lock()
+	defer st.Unlock()
+	st.initialized = false
+	st.initializing = false
+	st.indexed = false
+	st.indexing = make(map[string]bool)
+	st.indexedFiles = 0
+	st.totalFiles = 0
+	st.initializedIn = 0
+	st.indexedIn = 0
+	st.announced = false
+}
+
+// indexingStarted marks the beginning of indexing reported under token.
+func (st *serverStatus) indexingStarted(token string) {
+	st.Lock()
+	defer st.Unlock()
+	if st.indexing == nil {
+		st.indexing = make(map[string]bool)
+	}
+	if len(st.indexing) == 0 {
+		st.indexingSince = time.Now()
+		st.indexedFiles = 0
+		st.totalFiles = 0
+	}
+	st.indexing[token] = true
+}
+
+// indexingEnded marks the end of indexing reported under token. Unknown
+// tokens are ignored, so progress of other work doesn't affect readiness.
+func (st *serverStatus) indexingEnded(token string) {
+	st.Lock()
+	defer st.Unlock()
+	if !st.indexing[token] {
+		return
+	}
+	delete(st.indexing, token)
+	if len(st.indexing) == 0 {
+		st.indexed = true
+		st.indexedIn = time.Since(st.indexingSince)
+	}
+}
+
+// progress follows $/progress notifications with an indexing title.
+func (st *serverStatus) progress(params KeyValue) {
+	jsParams, _ := json.Marshal(params)
+	p := progressParams{}
+	if err := json.Unmarshal(jsParams, &p); err != nil {
+		Log.Warn(err)
+		return
+	}
+	token := fmt.Sprint(p.Token)
+	switch p.Value.Kind {
+	case "begin":
+		if strings.Contains(strings.ToLower(p.Value.Title), "index") {
+			Log.WithField("token", token).Info(p.Value.Title)
+			st.indexingStarted(token)
+		}
+	case "report":
+		if m := fileCount.FindStringSubmatch(p.Value.Message); m != nil {
+			indexed, _ := strconv.Atoi(m[1])
+			total, _ := strconv.Atoi(m[2])
+			st.countFiles(token, indexed, total)
+		}
+	case "end":
+		st.indexingEnded(token)
+	}
+}
+
+// fileCount matches the "indexed/total" count of indexing progress messages.
+var fileCount = regexp.MustCompile(`(\d+)\s*/\s*(\d+)`)
+
+// countFiles records the files indexed so far under the indexing token.
+func (st *serverStatus) countFiles(token string, indexed, total int) {
+	st.Lock()
+	defer st.Unlock()
+	if !st.indexing[token] {
+		return
+	}
+	st.indexedFiles = indexed
+	st.totalFiles = total
+}
+
+// serenataProgress follows the indexing progress phpls reports with its own
+// notification, which has no begin and end but a percentage.
+func (st *serverStatus) serenataProgress(params KeyValue) {
+	st.Lock()
+	started := st.indexing[indexingToken]
+	st.Unlock()
+	if !started {
+		st.indexingStarted(indexingToken)
+	}
+	st.countFiles(indexingToken, params.int("sequenceOfIndexedItem", 0), params.int("totalItemsToIndex", 0))
+	if params.int("progressPercentage", 0) >= 100 {
+		st.indexingEnded(indexingToken)
+	}
+}
+
+func (st *serverStatus) ready() KeyValue {
+	st.Lock()
+	defer st.Unlock()
+	return KeyValue{
+		"ready":       st.initialized && st.indexed,
+		"initialized": st.initialized,
+		"indexed":     st.indexed,
+		"indexing":    len(st.indexing) > 0,
+	}
+}
+
+// timings returns how long initialize and indexing took, in milliseconds.
+func (st *serverStatus) timings() KeyValue {
+	st.Lock()
+	defer st.Unlock()
+	return KeyValue{
+		"initialize": st.initializedIn.Milliseconds(),
+		"index":      st.indexedIn.Milliseconds(),
+	}
+}
+
+// becameReady reports whether the server is initialized and indexed for the
+// first time since it was started. It returns true only once per start.
+func (st *serverStatus) becameReady() bool {
+	st.Lock()
+	defer st.Unlock()
+	if !st.initialized || !st.indexed || st.announced {
+		return false
+	}
+	st.announced = true
+	return true
+}
+
+// indexState describes the index: its state, how long indexing took or has
+// been running in milliseconds, and the files indexed when the server
+// reports them.
+func (st *serverStatus) indexState() KeyValue {
+	st.Lock()
+	defer st.Unlock()
+	var state KeyValue
+	switch {
+	case len(st.indexing) > 0:
+		state = KeyValue{"state": "indexing", "duration": time.Since(st.indexingSince).Milliseconds()}
+	case st.indexed:
+		state = KeyValue{"state": "indexed", "duration": st.indexedIn.Milliseconds()}
+	default:
+		return KeyValue{"state": "not indexed"}
+	}
+	if st.totalFiles > 0 {
+		state["files"] = KeyValue{"indexed": st.indexedFiles, "total": st.totalFiles}
+	}
+	return state
+}
+
+// readiness is the ready state with the startup timings of the bridge.
+func (s *mateServer) readiness() KeyValue {
+	state := s.status.ready()
+	timings := s.status.timings()
+	timings["spawn"] = s.client.spawnTime().Milliseconds()
+	state["timings"] = timings
+	return state
+}
+
+// checkReady logs and broadcasts a "ready" event once the server was
+// spawned, initialized and indexed.
+func (s *mateServer) checkReady() {
+	if !s.status.becameReady() {
+		return
+	}
+	state := s.readiness()
+	timings := state["timings"].(KeyValue)
+	Log.WithFields(log.Fields{
+		"spawn":      timings["spawn"],
+		"initialize": timings["initialize"],
+		"index":      timings["index"],
+	}).Info("Language server ready")
+	s.stream.broadcast("ready", state)
+}
+
+// serveReady answers GET /ready with the readiness, and status 503 until
+// the server is ready.
+func (s *mateServer) serveReady(w http.ResponseWriter) {
+	state := s.readiness()
+	w.Header().Set("Content-Type", "application/json")
+	if state["ready"] != true {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(state)
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestBecameReady(t *testing.T) {
+	st := serverStatus{}
+	if !st.startInitialize() {
+		t.Fatalf("Expected initialize to start")
+	}
+	st.setInitialized(true)
+	if st.becameReady() {
+		t.Errorf("Expected not ready before indexing")
+	}
+	st.indexingStarted(indexingToken)
+	st.indexingEnded(indexingToken)
+	if !st.becameReady() {
+		t.Errorf("Expected ready after indexing")
+	}
+	if st.becameReady() {
+		t.Errorf("Expected readiness to be reported once")
+	}
+	st.reset()
+	st.setInitialized(true)
+	st.indexingStarted(indexingToken)
+	st.indexingEnded(indexingToken)
+	if !st.becameReady() {
+		t.Errorf("Expected ready again after a restart")
+	}
+}
+
+func TestSerenataProgress(t *testing.T) {
+	st := serverStatus{}
+	st.setInitialized(true)
+	st.serenataProgress(KeyValue{"sequenceOfIndexedItem": float64(1), "totalItemsToIndex": float64(4), "progressPercentage": float64(25)})
+	if !st.isIndexing() {
+		t.Errorf("Expected indexing after the first progress")
+	}
+	st.serenataProgress(KeyValue{"sequenceOfIndexedItem": float64(2), "totalItemsToIndex": float64(4), "progressPercentage": float64(50)})
+	if st.ready()["ready"] != false {
+		t.Errorf("Expected not ready while indexing")
+	}
+	st.serenataProgress(KeyValue{"sequenceOfIndexedItem": float64(4), "totalItemsToIndex": float64(4), "progressPercentage": float64(100)})
+	if st.isIndexing() || st.ready()["ready"] != true {
+		t.Errorf("Expected ready once indexing reached 100%%, got %v", st.ready())
+	}
+}
+
+func TestIndexStateFiles(t *testing.T) {
+	st := serverStatus{}
+	st.progress(KeyValue{"token": "1", "value": KeyValue{"kind": "begin", "title": "Indexing"}})
+	st.progress(KeyValue{"token": "1", "value": KeyValue{"kind": "report", "message": "120/480 files"}})
+	files, ok := st.indexState()["files"].(KeyValue)
+	if !ok || files["indexed"] != 120 || files["total"] != 480 {
+		t.Errorf("Expected 120 of 480 files, but got %v", st.indexState())
+	}
+	// reports of other work don't count
+	st.progress(KeyValue{"token": "2", "value": KeyValue{"kind": "report", "message": "1/2"}})
+	st.progress(KeyValue{"token": "1", "value": KeyValue{"kind": "end"}})
+	state := st.indexState()
+	if files, _ := state["files"].(KeyValue); state["state"] != "indexed" || files["total"] != 480 {
+		t.Errorf("Expected the file count to stay after indexing, but got %v", state)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// streamBuffer is the number of events queued for a slow editor before new
+// ones are dropped.
+const streamBuffer = 64
+
+// streamEvent is a server sent event.
+type streamEvent struct {
+	name string
+	data interface{}
+}
+
+// eventStream fans out events to the editors listening on /events.
+type eventStream struct {
+	// clients maps the event channels to the session of the editor
+	clients map[chan streamEvent]string
+	sync.Mutex
+}
+
+func (st *eventStream) subscribe(session string) chan streamEvent {
+	st.Lock()
+	defer st.Unlock()
+	if st.clients == nil {
+		st.clients = make(map[chan streamEvent]string)
+	}
+	ch := make(chan streamEvent, streamBuffer)
+	st.clients[ch] = session
+	return ch
+}
+
+func (st *eventStream) unsubscribe(ch chan streamEvent) {
+	st.Lock()
+	defer st.Unlock()
+	delete(st.clients, ch)
+}
+
+// broadcast sends the event to every listening editor without waiting for
+// the slow ones.
+func (st *eventStream) broadcast(name string, data interface{}) {
+	st.broadcastTo(nil, name, data)
+}
+
+// broadcastTo sends the event to the editors of the given sessions, all of
+// them when sessions is nil.
+func (st *eventStream) broadcastTo(sessions map[string]bool, name string, data interface{}) {
+	st.Lock()
+	defer st.Unlock()
+	for ch, session := range st.clients {
+		if sessions != nil && !sessions[session] {
+			continue
+		}
+		select {
+		case ch <- streamEvent{name, data}:
+		default:
+			Log.WithField("event", name).Warn("event stream is full, dropping event")
+		}
+	}
+}
+
+// serveEvents streams events to the editor until it disconnects.
+func (st *eventStream) serveEvents(w http.ResponseWriter, r *http.Request, session string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := st.subscribe(session)
+	defer st.unsubscribe(ch)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			data, err := json.Marshal(e.data)
+			if err != nil {
+				Log.Warn(err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxTraceEntries is the number of $/logTrace messages kept, older ones are
+// dropped.
+const maxTraceEntries = 1000
+
+// Trace levels of $/setTrace.
+const (
+	traceOff      = "off"
+	traceMessages = "messages"
+	traceVerbose  = "verbose"
+)
+
+// traceEntry is a $/logTrace message of the language server.
+type traceEntry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	Verbose string    `json:"verbose,omitempty"`
+}
+
+// traceStore keeps the protocol trace of the language server while tracing
+// is on. Tracing is off until the editor sets a level.
+type traceStore struct {
+	level   string
+	entries []traceEntry
+	sync.Mutex
+}
+
+func (t *traceStore) setLevel(level string) {
+	t.Lock()
+	defer t.Unlock()
+	t.level = level
+}
+
+// add keeps the entry, unless tracing was turned off.
+func (t *traceStore) add(entry traceEntry) bool {
+	t.Lock()
+	defer t.Unlock()
+	if t.level == traceOff || len(t.level) == 0 {
+		return false
+	}
+	t.entries = append(t.entries, entry)
+	if len(t.entries) > maxTraceEntries {
+		t.entries = t.entries[len(t.entries)-maxTraceEntries:]
+	}
+	return true
+}
+
+// snapshot returns the trace level and the kept entries, dropping them if
+// clear is set.
+func (t *traceStore) snapshot(clear bool) (string, []traceEntry) {
+	t.Lock()
+	defer t.Unlock()
+	entries := append([]traceEntry{}, t.entries...)
+	if clear {
+		t.entries = nil
+	}
+	return t.level, entries
+}
+
+// onSetTrace changes the trace level of the language server at runtime.
+func (s *mateServer) onSetTrace(mr mateRequest, cb kvChan) {
+	params := struct {
+		Value string `json:"value"`
+	}{}
+	if err := json.Unmarshal(mr.Body, &params); err != nil {
+		cb <- &KeyValue{"result": "error", "message": err.Error()}
+		return
+	}
+	switch params.Value {
+	case traceOff, traceMessages, traceVerbose:
+	default:
+		cb <- &KeyValue{"result": "error", "message": fmt.Sprintf("invalid trace value %q", params.Value)}
+		return
+	}
+	s.traces.setLevel(params.Value)
+	s.client.notification("$/setTrace", params)
+	cb <- &KeyValue{"result": "ok"}
+}
+
+// onGetTrace returns the captured $/logTrace messages, "clear" drops them.
+func (s *mateServer) onGetTrace(mr mateRequest, cb kvChan) {
+	params := struct {
+		Clear bool `json:"clear"`
+	}{}
+	if len(mr.Body) > 0 {
+		if err := json.Unmarshal(mr.Body, &params); err != nil {
+			cb <- &KeyValue{"result": "error", "message": err.Error()}
+			return
+		}
+	}
+	level, entries := s.traces.snapshot(params.Clear)
+	cb <- &KeyValue{"result": KeyValue{"level": level, "entries": entries}}
+}
+
+// logTrace captures a $/logTrace notification and streams it to the editors.
+func (s *mateServer) logTrace(params KeyValue) {
+	entry := traceEntry{Time: time.Now(), Message: params.string("message", ""), Verbose: params.string("verbose", "")}
+	Log.WithField("verbose", entry.Verbose).Debug(entry.Message)
+	if s.traces.add(entry) {
+		s.stream.broadcast("logTrace", entry)
+	}
+}
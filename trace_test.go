@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestTraceStore(t *testing.T) {
+	traces := traceStore{}
+	if traces.add(traceEntry{Message: "early"}) {
+		t.Errorf("Entry kept before tracing was turned on")
+	}
+
+	traces.setLevel(traceMessages)
+	for i := 0; i < maxTraceEntries+5; i++ {
+		traces.add(traceEntry{Message: "request"})
+	}
+	if _, entries := traces.snapshot(false); len(entries) != maxTraceEntries {
+		t.Errorf("Expected %d entries kept, but got %d", maxTraceEntries, len(entries))
+	}
+	if _, entries := traces.snapshot(true); len(entries) != maxTraceEntries {
+		t.Errorf("Expected the entries before clearing, but got %d", len(entries))
+	}
+	if _, entries := traces.snapshot(false); len(entries) != 0 {
+		t.Errorf("Expected no entries after clearing, but got %d", len(entries))
+	}
+
+	traces.setLevel(traceOff)
+	if traces.add(traceEntry{Message: "late"}) {
+		t.Errorf("Entry kept after tracing was turned off")
+	}
+}
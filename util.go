@@ -1,12 +1,19 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"os/exec"
 	"path"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -75,3 +82,89 @@ func userHomeDir() string {
 	}
 	return os.Getenv("HOME")
 }
+
+// uriToPath converts a file:// document uri to a local file path.
+func uriToPath(uri DocumentURI) string {
+	u, err := url.Parse(string(uri))
+	if err != nil || u.Scheme != "file" {
+		return strings.TrimPrefix(string(uri), "file://")
+	}
+	return u.Path
+}
+
+// processAlive reports whether the process with the given pid is running.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	defer p.Release()
+	if runtime.GOOS == "windows" {
+		// FindProcess fails for processes that don't exist
+		return true
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// processMemory returns the resident memory of the process in bytes. It's
+// read from /proc on linux and from ps elsewhere, where available.
+func processMemory(pid int) (int64, error) {
+	if runtime.GOOS == "linux" {
+		return procMemory(pid)
+	}
+	if _, err := exec.LookPath("ps"); err != nil {
+		return 0, errors.New("process memory is not available on " + runtime.GOOS)
+	}
+	out, err := exec.Command("ps", "-o", "rss=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return 0, err
+	}
+	kb, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return kb * 1024, nil
+}
+
+// procMemory reads the resident memory of the process from /proc.
+func procMemory(pid int) (int64, error) {
+	f, err := os.Open("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// VmRSS:	   12345 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "VmRSS:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			return kb * 1024, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("no VmRSS in /proc/" + strconv.Itoa(pid) + "/status")
+}
+
+// writableDir creates the directory if needed and checks that files can be
+// written to it. An unusable directory is replaced by a new temporary one.
+func writableDir(dir string) string {
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		var f *os.File
+		if f, err = ioutil.TempFile(dir, ".write-check"); err == nil {
+			f.Close()
+			os.Remove(f.Name())
+			return dir
+		}
+	}
+	tmp, tmpErr := ioutil.TempDir("", "intelephense")
+	if tmpErr != nil {
+		Log.WithField("dir", dir).Error(tmpErr)
+		return dir
+	}
+	Log.WithField("dir", dir).WithField("error", err).Warn("Storage path is not writable, using " + tmp)
+	return tmp
+}
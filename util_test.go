@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestProcessMemory(t *testing.T) {
+	memory, err := processMemory(os.Getpid())
+	if err != nil {
+		t.Skip(err)
+	}
+	if memory <= 0 {
+		t.Errorf("Expected the memory of the test process, but got %d", memory)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jsonKind is the type of a JSON value.
+type jsonKind string
+
+const (
+	jsonObject  jsonKind = "object"
+	jsonArray   jsonKind = "array"
+	jsonString  jsonKind = "string"
+	jsonNumber  jsonKind = "number"
+	jsonBoolean jsonKind = "boolean"
+	jsonNull    jsonKind = "null"
+)
+
+// schemaField is a field of a request body, addressed by its dotted path.
+type schemaField struct {
+	path     string
+	kind     jsonKind
+	optional bool
+}
+
+// requestSchema is the expected shape of the body of a request.
+type requestSchema struct {
+	body   jsonKind
+	fields []schemaField
+}
+
+var positionFields = []schemaField{
+	{path: "textDocument", kind: jsonObject},
+	{path: "textDocument.uri", kind: jsonString},
+	{path: "position", kind: jsonObject},
+	{path: "position.line", kind: jsonNumber},
+	{path: "position.character", kind: jsonNumber},
+}
+
+var rangeFields = []schemaField{
+	{path: "range", kind: jsonObject},
+	{path: "range.start.line", kind: jsonNumber},
+	{path: "range.start.character", kind: jsonNumber},
+	{path: "range.end.line", kind: jsonNumber},
+	{path: "range.end.character", kind: jsonNumber},
+}
+
+var uriFields = []schemaField{{path: "uri", kind: jsonString}}
+
+// requestSchemas lists the shapes of the request bodies. Methods without a
+// schema take no body or accept anything.
+var requestSchemas = map[string]requestSchema{
+	"hover": {jsonObject, append([]schemaField{
+		{path: "partial", kind: jsonBoolean, optional: true},
+		{path: "token", kind: jsonString, optional: true},
+	}, positionFields...)},
+	"completion": {jsonObject, append([]schemaField{
+		{path: "context", kind: jsonObject, optional: true},
+		{path: "context.triggerKind", kind: jsonNumber, optional: true},
+	}, positionFields...)},
+	"resolveCompletion":       {jsonObject, []schemaField{{path: "label", kind: jsonString}}},
+	"signatureHelp":           {jsonObject, positionFields},
+	"canTriggerSignatureHelp": {jsonObject, positionFields},
+	"definition":              {jsonObject, positionFields},
+	"references":              {jsonObject, positionFields},
+	"workspaceSymbol":         {jsonObject, []schemaField{{path: "query", kind: jsonString}}},
+	"rename":                  {jsonObject, append([]schemaField{{path: "newName", kind: jsonString}}, positionFields...)},
+	"codeAction": {jsonObject, append([]schemaField{
+		{path: "textDocument.uri", kind: jsonString},
+		{path: "context", kind: jsonObject, optional: true},
+	}, rangeFields...)},
+	"executeCommand": {jsonObject, []schemaField{
+		{path: "command", kind: jsonString},
+		{path: "arguments", kind: jsonArray, optional: true},
+	}},
+	"organizeImports": {jsonObject, uriFields},
+	"initialize":      {jsonObject, []schemaField{{path: "dir", kind: jsonString, optional: true}}},
+	"didOpen": {jsonObject, []schemaField{
+		{path: "uri", kind: jsonString},
+		{path: "text", kind: jsonString},
+		{path: "languageId", kind: jsonString, optional: true},
+		{path: "version", kind: jsonNumber, optional: true},
+		{path: "transient", kind: jsonBoolean, optional: true},
+	}},
+	"didClose":               {jsonObject, uriFields},
+	"didCloseBatch":          {jsonArray, nil},
+	"resolveCompletionBatch": {jsonArray, nil},
+	"didOpenBatch":           {jsonArray, nil},
+	"hoverBatch":             {jsonArray, nil},
+	"validate":               {jsonObject, uriFields},
+	"prewarm":                {jsonObject, []schemaField{{path: "uris", kind: jsonArray}, {path: "timeout", kind: jsonNumber, optional: true}}},
+	"waitDiagnostics":        {jsonObject, append([]schemaField{{path: "version", kind: jsonNumber, optional: true}, {path: "timeout", kind: jsonNumber, optional: true}}, uriFields...)},
+	"getDiagnostics":         {jsonObject, append([]schemaField{{path: "sources", kind: jsonArray, optional: true}}, uriFields...)},
+	"setTrace":               {jsonObject, []schemaField{{path: "value", kind: jsonString}}},
+	"didChangeConfiguration": {jsonObject, []schemaField{{path: "settings", kind: jsonObject}}},
+}
+
+// validateRequest checks the body against the schema of the method and
+// describes the first mismatch.
+func validateRequest(mr mateRequest) error {
+	schema, ok := requestSchemas[mr.Method]
+	if !ok {
+		return nil
+	}
+	var body interface{}
+	if err := json.Unmarshal(mr.Body, &body); err != nil {
+		return fmt.Errorf("invalid %s request: body is not valid JSON: %s", mr.Method, err)
+	}
+	if kind := kindOf(body); kind != schema.body {
+		return fmt.Errorf("invalid %s request: body must be %s, got %s", mr.Method, schema.body, kind)
+	}
+	for _, field := range schema.fields {
+		value, found := lookupField(body, field.path)
+		if !found {
+			if field.optional {
+				continue
+			}
+			return fmt.Errorf("invalid %s request: missing field %s", mr.Method, field.path)
+		}
+		if kind := kindOf(value); kind != field.kind && !(field.optional && kind == jsonNull) {
+			return fmt.Errorf("invalid %s request: field %s must be %s, got %s", mr.Method, field.path, field.kind, kind)
+		}
+	}
+	return nil
+}
+
+// lookupField finds the value at the dotted path.
+func lookupField(value interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func kindOf(value interface{}) jsonKind {
+	switch value.(type) {
+	case map[string]interface{}:
+		return jsonObject
+	case []interface{}:
+		return jsonArray
+	case string:
+		return jsonString
+	case float64:
+		return jsonNumber
+	case bool:
+		return jsonBoolean
+	}
+	return jsonNull
+}
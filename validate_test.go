@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		method string
+		body   string
+		want   string
+	}{
+		{"hover", `{"textDocument":{"uri":"file:///a.php"},"position":{"line":1,"character":2}}`, ""},
+		{"hover", `{"textDocument":{"uri":"file:///a.php"}}`, "invalid hover request: missing field position"},
+		{"hover", `{"textDocument":{"uri":1},"position":{"line":1,"character":2}}`, "invalid hover request: field textDocument.uri must be string, got number"},
+		{"didCloseBatch", `{"uris":[]}`, "invalid didCloseBatch request: body must be array, got object"},
+		{"didOpen", `{"uri":"file:///a.php","text":"<?php","languageId":null}`, ""},
+		{"completion", `{"textDocument":{"uri":"file:///a.php"},"position":{"line":1,"character":2},"context":{"triggerKind":"1"}}`, "invalid completion request: field context.triggerKind must be number, got string"},
+		{"ready", ``, ""},
+		{"didClose", `{"uri":`, "invalid didClose request: body is not valid JSON: unexpected end of JSON input"},
+	}
+
+	for _, test := range tests {
+		err := validateRequest(mateRequest{Method: test.method, Body: json.RawMessage(test.body)})
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.want {
+			t.Errorf("Validated %s %s, expected %q, but got %q", test.method, test.body, test.want, got)
+		}
+	}
+}